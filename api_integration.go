@@ -2,17 +2,12 @@ package apiintegration
 
 import (
 	"bytes"
-	"encoding/json"
-	"errors"
-	"fmt"
+	"context"
 	"io"
 	"io/ioutil"
-	"log"
-	"net"
+	"log/slog"
 	"net/http"
 	"time"
-
-	"github.com/stieya/activity"
 )
 
 type APIIntegration struct {
@@ -22,104 +17,251 @@ type APIIntegration struct {
 	Method      string
 	ContentType string
 	Host        string
-	Timeout     int
+	Timeout     int // seconds, see Timeouts
 	ObjReq      interface{}
 	Headers     map[string]string
 	IsLocalAPI  bool
-	Logger      *log.Logger
+	Logger      Logger
+
+	// PathParams fills the {name} placeholders of Host, path escaped, e.g.
+	// "https://api.example.com/customers/{id}/orders".
+	PathParams map[string]string
+	// QueryParams is added to the query of Host. It accepts url.Values,
+	// map[string]string, map[string][]string, map[string]interface{} or a
+	// struct whose fields are named by their query tag, then their json tag,
+	// then their Go name. Slices become repeated keys. The query of Host is
+	// then re-encoded with its keys sorted, a key in both keeps the values of
+	// Host first.
+	QueryParams interface{}
+
+	// Timeouts bounds each phase of the call, its Overall timeout takes
+	// precedence over Timeout.
+	Timeouts Timeouts
+
+	// Auth sets the credentials of each request, they are masked in the
+	// recorded api activity. Nil with IsLocalAPI uses LocalTokenAuth.
+	Auth Authenticator
+	// Signer signs each request once its headers, credentials and body are
+	// set, the headers it sets are masked in the recorded api activity too.
+	Signer Signer
+
+	// Client sends the call through its connection pool, nil uses a pool
+	// shared by the package.
+	Client *Client
+
+	// BodyEncoder encodes ObjReq, nil picks the encoder registered for the
+	// media type of ContentType, or JSON when there is none. An ObjReq that
+	// is a []byte or an io.Reader is always sent as is.
+	BodyEncoder BodyEncoder
+
+	// Retry re-sends failed calls, nil sends each call once.
+	Retry *RetryPolicy
+
+	// Breaker short-circuits calls while the circuit of APICode is open.
+	Breaker *CircuitBreaker
+
+	// SuccessStatuses lists the status codes treated as success by Do, any
+	// other status is returned as an error. Empty means every 2xx status.
+	SuccessStatuses []int
+
+	// MaxCaptureBytes caps the request and response bodies recorded in the
+	// api activity, default 64KiB. Negative records no body.
+	MaxCaptureBytes int64
+
+	// RecordTimeout bounds how long a call waits for the recorder to take its
+	// api activity, default 5s.
+	RecordTimeout time.Duration
+
+	// Redaction masks secrets in the recorded api activity and in logs, nil
+	// uses DefaultRedactionRules.
+	Redaction *RedactionRules
+
+	// CorrelationHeader sends the correlation ID of the call, taken from the
+	// context or generated, default X-Request-ID. "traceparent" sends it as
+	// the trace ID of a W3C trace context, an ID that is not a valid trace ID
+	// is sent hashed while the activity and logs keep it as is.
+	CorrelationHeader string
 }
 
-func (a *APIIntegration) Send(db interface{}) ([]byte, error) {
-	// generate http request
-	client := http.Client{Timeout: a.validateTimeout() * time.Second}
-	req, err := http.NewRequest(a.Method, a.Host, a.generateBody())
+// Send calls the integration with a background context, see SendContext.
+func (a *APIIntegration) Send(recorder ActivityRecorder) ([]byte, error) {
+	return a.SendContext(context.Background(), recorder)
+}
+
+// SendContext calls the integration and returns the response body, see Do.
+func (a *APIIntegration) SendContext(ctx context.Context, recorder ActivityRecorder) ([]byte, error) {
+	response, err := a.Do(ctx, recorder)
 	if err != nil {
-		go a.writeLog("[" + a.APICode + "] - Failed Send - new request - Error: " + err.Error())
-		log.Println("[", a.APICode, "] - Failed new request - ", err.Error())
 		return nil, err
 	}
+	return response.Body, nil
+}
 
-	// set headers
-	a.generateHeaders(req)
+// Do calls the integration and returns the full response. The request is bound
+// to ctx, so cancellation and deadlines of ctx abort the outbound call. Every
+// attempt is recorded as an Activity with recorder, including the ones failing
+// before they are sent, nil records nothing. The recorder receives the
+// request-scoped values of ctx but not its cancellation, so that a failed call
+// is still recorded.
+//
+// A status outside SuccessStatuses is returned as an error together with the
+// response, so its status, headers and body can still be inspected.
+//
+// When Retry is set, failed attempts are sent again with a rebuilt request and
+// each attempt is recorded as its own api activity. When Breaker is set, every
+// attempt first checks the circuit of APICode and fails with KindCircuitOpen
+// while it is open. A 401 to a call whose Auth is a TokenInvalidator is sent
+// once more with a fresh token.
+func (a *APIIntegration) Do(ctx context.Context, recorder ActivityRecorder) (*Response, error) {
+	start := time.Now()
+	ctx = withCorrelationID(ctx)
+	encoded, err := a.encodeRequest()
+	if err != nil {
+		a.record(ctx, recorder, a.newFailedActivity(1, err))
+		a.writeLog(ctx, slog.LevelError, "failed encode request", "error", err.Error())
+		return nil, a.newError(KindEncode, 0, nil, err)
+	}
 
-	// set api activity
-	apiActivity := activity.NewAPIActivityRequest(a.UserID, a.Token, time.Now().Format("2006-01-02 15:04:05"), a.APICode, req)
+	maxAttempts := a.Retry.maxAttempts()
+	var resp *Response
+	reauthenticated := false
+	for attempt := 1; ; attempt++ {
+		ticket, err := a.allowCircuit(ctx)
+		if err != nil {
+			return resp, err
+		}
+		resp, err = a.do(ctx, recorder, encoded, attempt, start)
+		a.doneCircuit(ctx, ticket, err)
+		if !reauthenticated && a.tokenInvalidated(err) {
+			reauthenticated = true
+			maxAttempts++
+			continue
+		}
+		if err == nil || attempt >= maxAttempts || ctx.Err() != nil || !a.retryable(err) {
+			return resp, err
+		}
+
+		delay := a.Retry.delay(attempt, resp)
+		a.writeLog(ctx, slog.LevelWarn, "retry call", "attempt", attempt+1, "delay", delay, "error", err.Error())
+		if ctxErr := sleepContext(ctx, delay); ctxErr != nil {
+			return resp, a.newError(classifyError(ctxErr), 0, nil, ctxErr)
+		}
+	}
+}
 
-	// post to idm with send apiactivity
-	response, err := apiActivity.ClientDo(db, client, req)
-	defer func() {
-		response.Body.Close()
-	}()
+// encodedRequest is the part of a call built once and sent by every attempt.
+type encodedRequest struct {
+	url         string
+	body        []byte
+	contentType string
+}
 
-	if err, ok := err.(net.Error); ok && err.Timeout() {
-		go a.writeLog("[" + a.APICode + "] - Failed Send - Error: " + err.Error())
-		log.Println("[", a.APICode, "] - Failed timeout - ", err.Error())
-		return nil, errors.New("timeout api")
+func (a *APIIntegration) encodeRequest() (*encodedRequest, error) {
+	reqURL, err := a.generateURL()
+	if err != nil {
+		return nil, err
 	}
+	body, contentType, err := a.generateBody()
 	if err != nil {
-		go a.writeLog("[" + a.APICode + "] - Failed Send - post - Error: " + err.Error())
-		log.Println("[", a.APICode, "] - Failed post - ", err.Error())
 		return nil, err
 	}
-	if response.StatusCode == http.StatusForbidden {
-		go a.writeLog("[" + a.APICode + "] - Failed Send - read response body - Error: Forbidden")
-		log.Println("[", a.APICode, "] - Failed read response body - Forbidden")
-		return nil, errors.New("Forbidden")
+	return &encodedRequest{url: reqURL, body: body, contentType: contentType}, nil
+}
+
+// do sends a single attempt of the encoded call.
+func (a *APIIntegration) do(ctx context.Context, recorder ActivityRecorder, encoded *encodedRequest, attempt int, start time.Time) (*Response, error) {
+	ctx = withCallInfo(ctx, a, attempt)
+
+	// generate http request
+	var body io.Reader
+	if encoded.body != nil {
+		body = bytes.NewReader(encoded.body)
+	}
+	client := a.httpClient()
+	req, err := http.NewRequestWithContext(ctx, a.Method, encoded.url, body)
+	if err != nil {
+		a.record(ctx, recorder, a.newFailedActivity(attempt, err))
+		a.writeLog(ctx, slog.LevelError, "failed new request", "attempt", attempt, "error", err.Error())
+		return nil, a.newError(KindUnknown, 0, nil, err)
 	}
 
-	// read response from idm
-	body, err := ioutil.ReadAll(response.Body)
+	// set headers
+	creds, err := a.generateHeaders(ctx, req, encoded)
 	if err != nil {
-		go a.writeLog("[" + a.APICode + "] - Failed Send - read response body - Error: " + err.Error())
-		log.Println("[", a.APICode, "] - Failed read response body - ", err.Error())
-		return nil, err
+		a.record(ctx, recorder, a.newFailedActivity(attempt, err))
+		a.writeLog(ctx, slog.LevelError, "failed authenticate", "attempt", attempt, "error", err.Error())
+		return nil, a.newError(KindAuth, 0, nil, err)
 	}
 
-	// err = json.Unmarshal(body, &resp)
-	// if err != nil {
-	// 	go a.writeLog("[" + a.APICode + "] - Failed Send - unmarshal response body - Error: " + err.Error())
-	// 	log.Println("[", a.APICode, "] - Failed unmarshal response body - Error: ", err.Error())
-	// 	return nil, err
-	// }
+	// set api activity without credentials
+	activity := a.newActivity(attempt, redactCredentials(req, creds, a.redaction().replacement()))
 
-	return body, nil
-}
+	// post to idm
+	response, err := client.Do(req)
+	if err != nil {
+		activity.ResponseError = err.Error()
+		a.record(ctx, recorder, activity)
+		kind := classifyError(err)
+		a.writeLog(ctx, slog.LevelError, "failed send", "attempt", attempt, "duration", time.Since(start), "kind", kind.String(), "error", err.Error())
+		return nil, a.newError(kind, 0, nil, err)
+	}
+	// the activity is recorded once the body is consumed or closed
+	response.Body = a.captureResponse(ctx, recorder, activity, response)
+	defer response.Body.Close()
 
-func (a *APIIntegration) validateTimeout() time.Duration {
-	defaultTimeout := 5
-	if a.Timeout == 0 {
-		return time.Duration(defaultTimeout)
+	// read response from idm
+	respBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		kind := classifyError(err)
+		a.writeLog(ctx, slog.LevelError, "failed read response body", "attempt", attempt, "status", response.StatusCode, "duration", time.Since(start), "error", err.Error())
+		return nil, a.newError(kind, response.StatusCode, nil, err)
+	}
+	resp := newResponse(a.APICode, response, respBody, start, attempt)
+
+	if !a.isSuccess(response.StatusCode) {
+		if invalidator, ok := a.authenticator().(TokenInvalidator); ok && response.StatusCode == http.StatusUnauthorized {
+			invalidator.InvalidateToken(req)
+		}
+		var statusErr error
+		if response.StatusCode == http.StatusForbidden {
+			statusErr = ErrForbidden
+		}
+		a.writeLog(ctx, slog.LevelError, "failed status", "attempt", attempt, "status", response.StatusCode, "duration", resp.Duration)
+		return resp, a.newError(classifyStatus(response.StatusCode), response.StatusCode, respBody, statusErr)
 	}
-	return time.Duration(a.Timeout)
+
+	a.writeLog(ctx, slog.LevelInfo, "call completed", "attempt", attempt, "status", response.StatusCode, "duration", resp.Duration)
+	return resp, nil
 }
 
-func (a *APIIntegration) generateHeaders(req *http.Request) {
-	req.Header.Set("Content-Type", a.ContentType)
+// generateHeaders sets the headers, credentials and signature of req, and
+// returns the credentials set by the authenticator and the signer.
+func (a *APIIntegration) generateHeaders(ctx context.Context, req *http.Request, encoded *encodedRequest) (credentials, error) {
+	req.Header.Set("Content-Type", encoded.contentType)
+	a.setCorrelationHeader(ctx, req)
 	for key, value := range a.Headers {
 		req.Header.Set(key, value)
 	}
-	if a.IsLocalAPI {
-		req.Header.Set("Authorization", fmt.Sprintf("token = %s", a.Token))
-	}
+	return a.authenticate(ctx, req, encoded.body)
 }
 
-func (a *APIIntegration) generateBody() io.Reader {
-	if a.ObjReq != nil {
-		bodyRequest, err := json.Marshal(a.ObjReq)
-		if err == nil {
-			return bytes.NewBuffer(bodyRequest)
-		}
+// generateBody encodes ObjReq and returns the body with the content type to
+// send it with.
+func (a *APIIntegration) generateBody() ([]byte, string, error) {
+	if a.ObjReq == nil {
+		return nil, a.ContentType, nil
 	}
-	return nil
-}
-
-func (a *APIIntegration) writeLog(s string) {
-	if a.Logger == nil {
-		return
+	if body, ok, err := encodeRaw(a.ObjReq); ok {
+		return body, a.ContentType, err
 	}
 
-	str := fmt.Sprintf("[%d] - %s", a.UserID, s)
-	if a.Logger != nil {
-		go a.Logger.Printf("[%s] %q", EncodeMD5(ToString(str)), str)
+	encoder := a.BodyEncoder
+	if encoder == nil {
+		encoder = bodyEncoderFor(a.ContentType)
+	}
+	body, contentType, err := encoder.Encode(a.ObjReq)
+	if contentType == "" {
+		contentType = a.ContentType
 	}
+	return body, contentType, err
 }
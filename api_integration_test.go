@@ -0,0 +1,78 @@
+package apiintegration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoTransportErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + listener.Addr().String()
+	listener.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	untrusted := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// the client rejecting the certificate is logged by the server otherwise
+	untrusted.Config.ErrorLog = log.New(io.Discard, "", 0)
+	untrusted.StartTLS()
+	defer untrusted.Close()
+
+	tests := []struct {
+		name    string
+		host    string
+		timeout time.Duration
+		kind    ErrorKind
+	}{
+		{"bad url", "http://[::1", 0, KindUnknown},
+		{"connection refused", refused, 0, KindConnectionRefused},
+		{"dns failure", "http://api.example.invalid", 0, KindDNS},
+		{"timeout", slow.URL, 200 * time.Millisecond, KindTimeout},
+		{"tls failure", untrusted.URL, 0, KindTLS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIIntegration{
+				APICode:  "TEST",
+				Method:   http.MethodGet,
+				Host:     tt.host,
+				Timeouts: Timeouts{Overall: tt.timeout},
+			}
+			recorder := &MemoryRecorder{}
+			resp, err := a.Do(context.Background(), recorder)
+			if resp != nil {
+				t.Errorf("response = %+v, want nil", resp)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want an *APIError", err)
+			}
+			if apiErr.Kind != tt.kind {
+				t.Errorf("kind = %s, want %s: %v", apiErr.Kind, tt.kind, err)
+			}
+
+			activities := recorder.Activities()
+			if len(activities) != 1 {
+				t.Fatalf("recorded %d activities, want 1", len(activities))
+			}
+			if activities[0].RequestError == "" && activities[0].ResponseError == "" {
+				t.Errorf("activity records no error: %+v", activities[0])
+			}
+		})
+	}
+}
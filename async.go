@@ -0,0 +1,346 @@
+package apiintegration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultAsyncQueueSize     = 1024
+	defaultAsyncBatchSize     = 100
+	defaultAsyncFlushInterval = time.Second
+)
+
+// ErrRecorderClosed is returned by an AsyncRecorder once closed.
+var ErrRecorderClosed = errors.New("activity recorder closed")
+
+// OverflowPolicy decides what an AsyncRecorder does with an activity when its
+// queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue. A call recording through a
+	// full queue stalls until there is room or its RecordTimeout expires.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued activity.
+	OverflowDropOldest
+	// OverflowDropNew drops the activity being recorded.
+	OverflowDropNew
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNew:
+		return "drop-new"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// BatchRecorder is implemented by recorders able to record several activities
+// at once, AsyncRecorder uses it to write its batches.
+type BatchRecorder interface {
+	ActivityRecorder
+	RecordBatch(ctx context.Context, activities []Activity) error
+}
+
+// AsyncRecorderSettings configures an AsyncRecorder, zero fields fall back to
+// the defaults noted on each field.
+type AsyncRecorderSettings struct {
+	// QueueSize is the number of activities waiting to be written, default
+	// 1024.
+	QueueSize int
+	// Overflow is applied when the queue is full, default OverflowBlock.
+	Overflow OverflowPolicy
+	// BatchSize is the maximum number of activities written at once,
+	// default 100, at most QueueSize. A full batch is written without
+	// waiting for FlushInterval.
+	BatchSize int
+	// FlushInterval is how often queued activities are written, default 1
+	// second.
+	FlushInterval time.Duration
+	// Retry re-writes a batch that failed, only its attempts and delays
+	// apply. Nil writes each batch once.
+	Retry *RetryPolicy
+	// OnError is called with the activities given up after the last
+	// attempt. Nil logs the error with Logger.
+	OnError func(err error, activities []Activity)
+	// Logger logs the activities given up, nil logs nothing.
+	Logger Logger
+}
+
+// AsyncRecorderStats are the counters of an AsyncRecorder.
+type AsyncRecorderStats struct {
+	Queued   int
+	Recorded uint64
+	// Dropped counts the activities dropped by the overflow policy or left
+	// in the queue when Close gave up.
+	Dropped uint64
+	// Failed counts the activities given up after the last write attempt.
+	Failed uint64
+}
+
+// AsyncRecorder records activities in the background with a bounded queue,
+// writing them in batches with the recorder it wraps. Calls only wait for the
+// queue, Close must be called on shutdown to write what is left.
+type AsyncRecorder struct {
+	recorder ActivityRecorder
+	settings AsyncRecorderSettings
+
+	mu      sync.Mutex
+	queue   []Activity
+	closed  bool
+	wake    chan struct{}
+	space   chan struct{}
+	flushes chan chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+
+	// ctx aborts the writes in flight when Close gives up.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	recorded atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// NewAsyncRecorder returns an AsyncRecorder writing to recorder, in batches
+// when it is a BatchRecorder, and starts its writer.
+func NewAsyncRecorder(recorder ActivityRecorder, settings AsyncRecorderSettings) *AsyncRecorder {
+	if settings.QueueSize <= 0 {
+		settings.QueueSize = defaultAsyncQueueSize
+	}
+	if settings.BatchSize <= 0 {
+		settings.BatchSize = defaultAsyncBatchSize
+	}
+	// a batch larger than the queue is never full
+	if settings.BatchSize > settings.QueueSize {
+		settings.BatchSize = settings.QueueSize
+	}
+	if settings.FlushInterval <= 0 {
+		settings.FlushInterval = defaultAsyncFlushInterval
+	}
+	r := &AsyncRecorder{
+		recorder: recorder,
+		settings: settings,
+		wake:     make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+		flushes:  make(chan chan struct{}),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	go r.run()
+	return r
+}
+
+// Record queues activity. With OverflowBlock it waits for room in the queue
+// until ctx is done.
+func (r *AsyncRecorder) Record(ctx context.Context, activity Activity) error {
+	for {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return ErrRecorderClosed
+		}
+		if len(r.queue) < r.settings.QueueSize {
+			r.queue = append(r.queue, activity)
+			full, room := len(r.queue) >= r.settings.BatchSize, len(r.queue) < r.settings.QueueSize
+			r.mu.Unlock()
+			if full {
+				signal(r.wake)
+			}
+			// pass the room on to the next blocked caller
+			if room {
+				signal(r.space)
+			}
+			return nil
+		}
+
+		switch r.settings.Overflow {
+		case OverflowDropOldest:
+			r.queue = append(r.queue[1:], activity)
+			r.mu.Unlock()
+			r.dropped.Add(1)
+			return nil
+		case OverflowDropNew:
+			r.mu.Unlock()
+			r.dropped.Add(1)
+			return nil
+		}
+		r.mu.Unlock()
+
+		signal(r.wake)
+		select {
+		case <-r.space:
+		case <-r.closing:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Flush writes every activity queued before the call, or returns when ctx is
+// done.
+func (r *AsyncRecorder) Flush(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case r.flushes <- reply:
+	case <-r.done:
+		return ErrRecorderClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting activities and writes the queued ones. When ctx is
+// done first, the writes in flight are aborted and the rest of the queue is
+// dropped.
+func (r *AsyncRecorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.closing)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-r.done
+		return ctx.Err()
+	}
+}
+
+// Stats returns the current counters of r.
+func (r *AsyncRecorder) Stats() AsyncRecorderStats {
+	r.mu.Lock()
+	queued := len(r.queue)
+	r.mu.Unlock()
+
+	return AsyncRecorderStats{
+		Queued:   queued,
+		Recorded: r.recorded.Load(),
+		Dropped:  r.dropped.Load(),
+		Failed:   r.failed.Load(),
+	}
+}
+
+func (r *AsyncRecorder) run() {
+	defer close(r.done)
+	defer r.cancel()
+
+	ticker := time.NewTicker(r.settings.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.wake:
+			r.flush(false)
+		case <-ticker.C:
+			r.flush(true)
+		case reply := <-r.flushes:
+			r.flush(true)
+			close(reply)
+		case <-r.closing:
+			r.flush(true)
+			return
+		}
+	}
+}
+
+// flush writes the queue in batches, all of it or only its full batches.
+func (r *AsyncRecorder) flush(all bool) {
+	for {
+		r.mu.Lock()
+		n := len(r.queue)
+		if n > r.settings.BatchSize {
+			n = r.settings.BatchSize
+		}
+		if n == 0 || (!all && n < r.settings.BatchSize) {
+			r.mu.Unlock()
+			return
+		}
+		batch := make([]Activity, n)
+		copy(batch, r.queue)
+		r.queue = r.queue[n:]
+		r.mu.Unlock()
+		signal(r.space)
+
+		if r.ctx.Err() != nil {
+			r.dropped.Add(uint64(len(batch)))
+			continue
+		}
+		r.write(batch)
+	}
+}
+
+// write records batch, retrying with the settings Retry.
+func (r *AsyncRecorder) write(batch []Activity) {
+	maxAttempts := r.settings.Retry.maxAttempts()
+	for attempt := 1; ; attempt++ {
+		var err error
+		batch, err = r.writeOnce(batch)
+		if err == nil {
+			return
+		}
+		if attempt >= maxAttempts || sleepContext(r.ctx, r.settings.Retry.delay(attempt, nil)) != nil {
+			r.failed.Add(uint64(len(batch)))
+			if r.settings.OnError != nil {
+				r.settings.OnError(err, batch)
+			} else if r.settings.Logger != nil {
+				r.settings.Logger.Log(r.ctx, slog.LevelError, "failed record api activity", "activities", len(batch), "error", err.Error())
+			}
+			return
+		}
+	}
+}
+
+// writeOnce records batch and returns the activities left to record.
+func (r *AsyncRecorder) writeOnce(batch []Activity) ([]Activity, error) {
+	if recorder, ok := r.recorder.(BatchRecorder); ok {
+		if err := recorder.RecordBatch(r.ctx, batch); err != nil {
+			return batch, err
+		}
+		r.recorded.Add(uint64(len(batch)))
+		return nil, nil
+	}
+
+	var failed []Activity
+	var errs []error
+	for _, activity := range batch {
+		if err := r.recorder.Record(r.ctx, activity); err != nil {
+			failed = append(failed, activity)
+			errs = append(errs, err)
+			continue
+		}
+		r.recorded.Add(1)
+	}
+	return failed, errors.Join(errs...)
+}
+
+// signal wakes a waiter of ch without blocking.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,243 @@
+package apiintegration
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// gatedRecorder holds each Record until gate is closed or ctx is done, and
+// reports the attempt of the activity held on entered.
+type gatedRecorder struct {
+	MemoryRecorder
+	entered chan int
+	gate    chan struct{}
+}
+
+func newGatedRecorder() *gatedRecorder {
+	return &gatedRecorder{entered: make(chan int, 100), gate: make(chan struct{})}
+}
+
+func (r *gatedRecorder) Record(ctx context.Context, activity Activity) error {
+	r.entered <- activity.Attempt
+	select {
+	case <-r.gate:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r.MemoryRecorder.Record(ctx, activity)
+}
+
+// recordedAttempts returns the attempts of the activities recorded by r, in
+// order.
+func recordedAttempts(r interface{ Activities() []Activity }) []int {
+	var attempts []int
+	for _, activity := range r.Activities() {
+		attempts = append(attempts, activity.Attempt)
+	}
+	return attempts
+}
+
+// fillQueue records activity 1, held by the writer, and activity 2, which
+// fills the queue of one.
+func fillQueue(t *testing.T, overflow OverflowPolicy) (*AsyncRecorder, *gatedRecorder) {
+	t.Helper()
+	gated := newGatedRecorder()
+	r := NewAsyncRecorder(gated, AsyncRecorderSettings{QueueSize: 1, Overflow: overflow, FlushInterval: time.Hour})
+	if err := r.Record(context.Background(), Activity{Attempt: 1}); err != nil {
+		t.Fatal(err)
+	}
+	<-gated.entered
+	if err := r.Record(context.Background(), Activity{Attempt: 2}); err != nil {
+		t.Fatal(err)
+	}
+	return r, gated
+}
+
+func TestAsyncRecorderOverflowBlock(t *testing.T) {
+	r, gated := fillQueue(t, OverflowBlock)
+	recorded := make(chan error)
+	go func() { recorded <- r.Record(context.Background(), Activity{Attempt: 3}) }()
+	select {
+	case err := <-recorded:
+		t.Fatalf("Record returned %v on a full queue", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// writing the queue makes room
+	close(gated.gate)
+	if err := <-recorded; err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if attempts := recordedAttempts(gated); !reflect.DeepEqual(attempts, []int{1, 2, 3}) {
+		t.Errorf("recorded %v, want [1 2 3]", attempts)
+	}
+	r.Close(context.Background())
+}
+
+func TestAsyncRecorderOverflowBlockContext(t *testing.T) {
+	r, gated := fillQueue(t, OverflowBlock)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Record(ctx, Activity{Attempt: 3}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+
+	close(gated.gate)
+	r.Close(context.Background())
+	if attempts := recordedAttempts(gated); !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Errorf("recorded %v, want [1 2]", attempts)
+	}
+}
+
+func TestAsyncRecorderOverflowDrop(t *testing.T) {
+	tests := []struct {
+		overflow OverflowPolicy
+		want     []int
+	}{
+		{OverflowDropOldest, []int{1, 3}},
+		{OverflowDropNew, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.overflow.String(), func(t *testing.T) {
+			r, gated := fillQueue(t, tt.overflow)
+			if err := r.Record(context.Background(), Activity{Attempt: 3}); err != nil {
+				t.Fatal(err)
+			}
+			if dropped := r.Stats().Dropped; dropped != 1 {
+				t.Errorf("dropped = %d, want 1", dropped)
+			}
+
+			close(gated.gate)
+			r.Close(context.Background())
+			if attempts := recordedAttempts(gated); !reflect.DeepEqual(attempts, tt.want) {
+				t.Errorf("recorded %v, want %v", attempts, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsyncRecorderFlush(t *testing.T) {
+	memory := &MemoryRecorder{}
+	r := NewAsyncRecorder(memory, AsyncRecorderSettings{FlushInterval: time.Hour})
+	defer r.Close(context.Background())
+
+	for i := 1; i <= 3; i++ {
+		r.Record(context.Background(), Activity{Attempt: i})
+	}
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if attempts := recordedAttempts(memory); !reflect.DeepEqual(attempts, []int{1, 2, 3}) {
+		t.Errorf("recorded %v after Flush, want [1 2 3]", attempts)
+	}
+	if stats := r.Stats(); stats.Queued != 0 || stats.Recorded != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestAsyncRecorderCloseGivesUp(t *testing.T) {
+	gated := newGatedRecorder()
+	r := NewAsyncRecorder(gated, AsyncRecorderSettings{BatchSize: 1, FlushInterval: time.Hour})
+	for i := 1; i <= 3; i++ {
+		r.Record(context.Background(), Activity{Attempt: i})
+	}
+	<-gated.entered
+
+	// the write of activity 1 never completes
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+	stats := r.Stats()
+	if stats.Recorded != 0 || stats.Failed != 1 || stats.Dropped != 2 || stats.Queued != 0 {
+		t.Errorf("stats = %+v, want 1 failed and 2 dropped", stats)
+	}
+}
+
+func TestAsyncRecorderRetriesFailedRows(t *testing.T) {
+	flaky := &flakyRecorder{fails: map[int]bool{2: true}}
+	r := NewAsyncRecorder(flaky, AsyncRecorderSettings{
+		FlushInterval: time.Hour,
+		Retry:         &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	for i := 1; i <= 3; i++ {
+		r.Record(context.Background(), Activity{Attempt: i})
+	}
+	r.Close(context.Background())
+
+	if attempts := recordedAttempts(flaky); !reflect.DeepEqual(attempts, []int{1, 3, 2}) {
+		t.Errorf("recorded %v, want [1 3 2]", attempts)
+	}
+	if stats := r.Stats(); stats.Recorded != 3 || stats.Failed != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestAsyncRecorderOnError(t *testing.T) {
+	// activity 2 fails on its first write and on its retry
+	flaky := &flakyRecorder{fails: map[int]bool{2: true, 4: true}}
+	var givenUp []int
+	r := NewAsyncRecorder(flaky, AsyncRecorderSettings{
+		FlushInterval: time.Hour,
+		Retry:         &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		OnError: func(err error, activities []Activity) {
+			for _, activity := range activities {
+				givenUp = append(givenUp, activity.Attempt)
+			}
+		},
+	})
+	for i := 1; i <= 3; i++ {
+		r.Record(context.Background(), Activity{Attempt: i})
+	}
+	r.Close(context.Background())
+
+	if !reflect.DeepEqual(givenUp, []int{2}) {
+		t.Errorf("given up %v, want [2]", givenUp)
+	}
+	if attempts := recordedAttempts(flaky); !reflect.DeepEqual(attempts, []int{1, 3}) {
+		t.Errorf("recorded %v, want [1 3]", attempts)
+	}
+	if stats := r.Stats(); stats.Recorded != 2 || stats.Failed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestAsyncRecorderClosed(t *testing.T) {
+	r := NewAsyncRecorder(&MemoryRecorder{}, AsyncRecorderSettings{})
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Record(context.Background(), Activity{}); !errors.Is(err, ErrRecorderClosed) {
+		t.Errorf("Record error = %v, want ErrRecorderClosed", err)
+	}
+	if err := r.Flush(context.Background()); !errors.Is(err, ErrRecorderClosed) {
+		t.Errorf("Flush error = %v, want ErrRecorderClosed", err)
+	}
+}
+
+func TestAsyncRecorderClampsBatchSize(t *testing.T) {
+	memory := &MemoryRecorder{}
+	r := NewAsyncRecorder(memory, AsyncRecorderSettings{QueueSize: 2, BatchSize: 10, FlushInterval: time.Hour})
+	defer r.Close(context.Background())
+
+	for i := 1; i <= 2; i++ {
+		if err := r.Record(context.Background(), Activity{Attempt: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the full queue is a full batch, written without waiting an hour
+	deadline := time.Now().Add(5 * time.Second)
+	for len(memory.Activities()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("recorded %d activities, want 2", len(memory.Activities()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
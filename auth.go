@@ -0,0 +1,162 @@
+package apiintegration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const redacted = "[REDACTED]"
+
+// Authenticator sets the credentials of an outbound request. It runs after
+// the Headers of the integration are set.
+type Authenticator interface {
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator, to plug in custom
+// schemes.
+type AuthenticatorFunc func(ctx context.Context, req *http.Request) error
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, req *http.Request) error {
+	return f(ctx, req)
+}
+
+// BearerAuth sends "Authorization: Bearer <Token>".
+type BearerAuth struct {
+	Token string
+}
+
+func (b BearerAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+b.Token)
+	return nil
+}
+
+// BasicAuth sends HTTP basic credentials.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (b BasicAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	req.SetBasicAuth(b.Username, b.Password)
+	return nil
+}
+
+// APIKeyAuth sends Value in the header Name, or in the query key Name when
+// InQuery is set. Name defaults to X-API-Key.
+type APIKeyAuth struct {
+	Name    string
+	Value   string
+	InQuery bool
+}
+
+func (k APIKeyAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	name := k.Name
+	if name == "" {
+		name = "X-API-Key"
+	}
+	if !k.InQuery {
+		req.Header.Set(name, k.Value)
+		return nil
+	}
+	query := req.URL.Query()
+	query.Set(name, k.Value)
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// LocalTokenAuth sends "Authorization: token = <Token>", the scheme of our
+// local APIs used when IsLocalAPI is set.
+type LocalTokenAuth struct {
+	Token string
+}
+
+func (l LocalTokenAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", fmt.Sprintf("token = %s", l.Token))
+	return nil
+}
+
+func (a *APIIntegration) authenticator() Authenticator {
+	if a.Auth != nil {
+		return a.Auth
+	}
+	if a.IsLocalAPI {
+		return LocalTokenAuth{Token: a.Token}
+	}
+	return nil
+}
+
+// credentials are the header names and query keys set by an Authenticator.
+type credentials struct {
+	headers   []string
+	queryKeys []string
+}
+
+// authenticate applies the authenticator then the signer of a to req, and
+// returns the credentials they set.
+func (a *APIIntegration) authenticate(ctx context.Context, req *http.Request, body []byte) (credentials, error) {
+	var creds credentials
+	auth := a.authenticator()
+	if auth == nil && a.Signer == nil {
+		return creds, nil
+	}
+
+	header := req.Header.Clone()
+	query := req.URL.Query()
+	if auth != nil {
+		if err := auth.Authenticate(ctx, req); err != nil {
+			return creds, err
+		}
+	}
+	if a.Signer != nil {
+		if err := a.Signer.Sign(req, body); err != nil {
+			return creds, err
+		}
+	}
+	for key, values := range req.Header {
+		if !equalValues(header[key], values) {
+			creds.headers = append(creds.headers, key)
+		}
+	}
+	for key, values := range req.URL.Query() {
+		if !equalValues(query[key], values) {
+			creds.queryKeys = append(creds.queryKeys, key)
+		}
+	}
+	return creds, nil
+}
+
+// redactCredentials returns a copy of req, with its own body, whose
+// credentials are masked, to be recorded as api activity.
+func redactCredentials(req *http.Request, creds credentials, replacement string) *http.Request {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			clone.Body = body
+		}
+	}
+	for _, key := range creds.headers {
+		clone.Header.Set(key, replacement)
+	}
+	if len(creds.queryKeys) > 0 {
+		query := clone.URL.Query()
+		for _, key := range creds.queryKeys {
+			query.Set(key, replacement)
+		}
+		clone.URL.RawQuery = query.Encode()
+	}
+	return clone
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package apiintegration
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticators(t *testing.T) {
+	var sent *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		auth       Authenticator
+		isLocalAPI bool
+		header     string
+		sentValue  string
+		sentQuery  string
+		recorded   string
+	}{
+		{name: "bearer", auth: BearerAuth{Token: "s3cret"}, header: "Authorization", sentValue: "Bearer s3cret",
+			recorded: "Authorization: [REDACTED]\r\n"},
+		{name: "basic", auth: BasicAuth{Username: "user", Password: "s3cret"}, header: "Authorization", sentValue: "Basic dXNlcjpzM2NyZXQ=",
+			recorded: "Authorization: [REDACTED]\r\n"},
+		{name: "api key header", auth: APIKeyAuth{Value: "s3cret"}, header: "X-Api-Key", sentValue: "s3cret",
+			recorded: "X-Api-Key: [REDACTED]\r\n"},
+		{name: "api key custom header", auth: APIKeyAuth{Name: "X-Partner-Key", Value: "s3cret"}, header: "X-Partner-Key", sentValue: "s3cret",
+			recorded: "X-Partner-Key: [REDACTED]\r\n"},
+		{name: "api key query", auth: APIKeyAuth{Name: "key", Value: "s3cret", InQuery: true}, sentQuery: "id=1&key=s3cret",
+			recorded: "GET /orders?id=1&key=%5BREDACTED%5D HTTP/1.1\r\n"},
+		{name: "local token", isLocalAPI: true, header: "Authorization", sentValue: "token = s3cret",
+			recorded: "Authorization: [REDACTED]\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIIntegration{
+				APICode:    "TEST",
+				Method:     http.MethodGet,
+				Host:       server.URL + "/orders?id=1",
+				Token:      "s3cret",
+				Headers:    map[string]string{"X-Trace": "trace-1"},
+				IsLocalAPI: tt.isLocalAPI,
+				Auth:       tt.auth,
+				// mask nothing but the credentials set by the authenticator
+				Redaction: &RedactionRules{},
+			}
+			recorder := &MemoryRecorder{}
+			if _, err := a.Do(context.Background(), recorder); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.header != "" && sent.Header.Get(tt.header) != tt.sentValue {
+				t.Errorf("%s = %q, want %q", tt.header, sent.Header.Get(tt.header), tt.sentValue)
+			}
+			if tt.sentQuery != "" && sent.URL.RawQuery != tt.sentQuery {
+				t.Errorf("query = %q, want %q", sent.URL.RawQuery, tt.sentQuery)
+			}
+			if sent.Header.Get("X-Trace") != "trace-1" {
+				t.Errorf("X-Trace = %q", sent.Header.Get("X-Trace"))
+			}
+
+			activity := recorder.Activities()[0]
+			if !strings.Contains(activity.Request, tt.recorded) {
+				t.Errorf("request = %q, want %q", activity.Request, tt.recorded)
+			}
+			if strings.Contains(activity.Request, "s3cret") || strings.Contains(activity.Request, "dXNlcjpzM2NyZXQ=") {
+				t.Errorf("credentials recorded: %q", activity.Request)
+			}
+			if !strings.Contains(activity.Request, "X-Trace: trace-1\r\n") {
+				t.Errorf("other headers masked: %q", activity.Request)
+			}
+			if activity.Token != "[REDACTED]" {
+				t.Errorf("token = %q", activity.Token)
+			}
+		})
+	}
+}
+
+func TestRedactCredentials(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://api.example.com/orders?key=s3cret&id=1", strings.NewReader(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Signature", "sig")
+	req.Header.Set("Content-Type", "application/json")
+
+	clone := redactCredentials(req, credentials{headers: []string{"X-Signature"}, queryKeys: []string{"key"}}, "***")
+	if clone.Header.Get("X-Signature") != "***" || clone.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("header = %v", clone.Header)
+	}
+	if clone.URL.RawQuery != "id=1&key=%2A%2A%2A" {
+		t.Errorf("query = %q", clone.URL.RawQuery)
+	}
+	if req.Header.Get("X-Signature") != "sig" || req.URL.RawQuery != "key=s3cret&id=1" {
+		t.Errorf("the request was modified: %v %q", req.Header, req.URL.RawQuery)
+	}
+
+	// the clone has a body of its own, the request can still be sent
+	cloneBody := new(strings.Builder)
+	reqBody := new(strings.Builder)
+	if _, err := io.Copy(cloneBody, clone.Body); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(reqBody, req.Body); err != nil {
+		t.Fatal(err)
+	}
+	if cloneBody.String() != `{"id":1}` || reqBody.String() != `{"id":1}` {
+		t.Errorf("bodies = %q, %q", cloneBody, reqBody)
+	}
+}
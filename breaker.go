@@ -0,0 +1,291 @@
+package apiintegration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerConsecutiveFailures = 5
+	defaultBreakerMinRequests         = 10
+	defaultBreakerWindow              = time.Minute
+	defaultBreakerCooldown            = 30 * time.Second
+	defaultBreakerHalfOpenRequests    = 1
+)
+
+// CircuitState is the state of the circuit of one APICode.
+type CircuitState int
+
+const (
+	StateClosed CircuitState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitBreakerSettings configures a CircuitBreaker, zero fields fall back to
+// the defaults noted on each field.
+type CircuitBreakerSettings struct {
+	// ConsecutiveFailures opens the circuit after that many failures in a
+	// row, default 5.
+	ConsecutiveFailures int
+	// FailureRate opens the circuit when the ratio of failed calls within
+	// Window reaches it, once MinRequests calls were made. Zero disables it.
+	FailureRate float64
+	// MinRequests is the number of calls within Window before FailureRate
+	// applies, default 10.
+	MinRequests int
+	// Window is the period after which the closed state counts reset,
+	// default 1 minute.
+	Window time.Duration
+	// Cooldown is how long the circuit stays open before letting probe
+	// calls through in half-open state, default 30 seconds.
+	Cooldown time.Duration
+	// HalfOpenRequests is the number of concurrent probe calls allowed in
+	// half-open state, default 1.
+	HalfOpenRequests int
+}
+
+// CircuitBreaker short-circuits calls to an APICode whose downstream keeps
+// failing. One CircuitBreaker is meant to be shared by every APIIntegration,
+// each APICode gets its own circuit.
+type CircuitBreaker struct {
+	settings CircuitBreakerSettings
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	// generation changes with state, so that done ignores the calls
+	// allowed in a previous state.
+	generation  uint64
+	state       CircuitState
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	probes      int
+}
+
+// circuitTicket identifies the state a call was allowed in.
+type circuitTicket struct {
+	generation uint64
+}
+
+type circuitTransition struct {
+	apiCode  string
+	from, to CircuitState
+}
+
+// NewCircuitBreaker returns a CircuitBreaker with settings. The zero
+// CircuitBreaker uses the default settings.
+func NewCircuitBreaker(settings CircuitBreakerSettings) *CircuitBreaker {
+	settings.setDefaults()
+	return &CircuitBreaker{
+		settings: settings,
+		circuits: map[string]*circuit{},
+	}
+}
+
+func (s *CircuitBreakerSettings) setDefaults() {
+	if s.ConsecutiveFailures <= 0 {
+		s.ConsecutiveFailures = defaultBreakerConsecutiveFailures
+	}
+	if s.MinRequests <= 0 {
+		s.MinRequests = defaultBreakerMinRequests
+	}
+	if s.Window <= 0 {
+		s.Window = defaultBreakerWindow
+	}
+	if s.Cooldown <= 0 {
+		s.Cooldown = defaultBreakerCooldown
+	}
+	if s.HalfOpenRequests <= 0 {
+		s.HalfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+}
+
+// State returns the current state of the circuit of apiCode.
+func (b *CircuitBreaker) State(apiCode string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[apiCode]
+	if !ok {
+		return StateClosed
+	}
+	if c.state == StateOpen && time.Since(c.openedAt) >= b.settings.Cooldown {
+		return StateHalfOpen
+	}
+	return c.state
+}
+
+// States returns the current state of every circuit known to b.
+func (b *CircuitBreaker) States() map[string]CircuitState {
+	b.mu.Lock()
+	apiCodes := make([]string, 0, len(b.circuits))
+	for apiCode := range b.circuits {
+		apiCodes = append(apiCodes, apiCode)
+	}
+	b.mu.Unlock()
+
+	states := make(map[string]CircuitState, len(apiCodes))
+	for _, apiCode := range apiCodes {
+		states[apiCode] = b.State(apiCode)
+	}
+	return states
+}
+
+// allow reports whether a call to apiCode may be sent. Every allowed call
+// must be followed by done with the returned ticket.
+func (b *CircuitBreaker) allow(apiCode string) (circuitTicket, bool, *circuitTransition) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(apiCode)
+	var transition *circuitTransition
+	if c.state == StateOpen {
+		if time.Since(c.openedAt) < b.settings.Cooldown {
+			return circuitTicket{}, false, nil
+		}
+		transition = b.setState(apiCode, c, StateHalfOpen)
+	}
+	if c.state == StateHalfOpen {
+		if c.probes >= b.settings.HalfOpenRequests {
+			return circuitTicket{}, false, transition
+		}
+		c.probes++
+	}
+	return circuitTicket{generation: c.generation}, true, transition
+}
+
+// done records the outcome of a call allowed by allow. The outcome of a call
+// allowed before the last change of state is ignored, such as a call started
+// while closed that finishes once half-open.
+func (b *CircuitBreaker) done(apiCode string, ticket circuitTicket, failed bool) *circuitTransition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(apiCode)
+	if ticket.generation != c.generation {
+		return nil
+	}
+	switch c.state {
+	case StateHalfOpen:
+		c.probes--
+		if failed {
+			return b.setState(apiCode, c, StateOpen)
+		}
+		return b.setState(apiCode, c, StateClosed)
+	case StateClosed:
+		if time.Since(c.windowStart) >= b.settings.Window {
+			c.windowStart = time.Now()
+			c.requests, c.failures = 0, 0
+		}
+		c.requests++
+		if !failed {
+			c.consecutive = 0
+			return nil
+		}
+		c.failures++
+		c.consecutive++
+		if c.consecutive >= b.settings.ConsecutiveFailures || b.rateExceeded(c) {
+			return b.setState(apiCode, c, StateOpen)
+		}
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) rateExceeded(c *circuit) bool {
+	if b.settings.FailureRate <= 0 || c.requests < b.settings.MinRequests {
+		return false
+	}
+	return float64(c.failures)/float64(c.requests) >= b.settings.FailureRate
+}
+
+func (b *CircuitBreaker) circuit(apiCode string) *circuit {
+	// the zero CircuitBreaker is set up on its first call
+	if b.circuits == nil {
+		b.settings.setDefaults()
+		b.circuits = map[string]*circuit{}
+	}
+	c, ok := b.circuits[apiCode]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.circuits[apiCode] = c
+	}
+	return c
+}
+
+func (b *CircuitBreaker) setState(apiCode string, c *circuit, state CircuitState) *circuitTransition {
+	transition := &circuitTransition{apiCode: apiCode, from: c.state, to: state}
+	c.state = state
+	c.generation++
+	c.probes = 0
+	switch state {
+	case StateOpen:
+		c.openedAt = time.Now()
+	case StateClosed:
+		c.windowStart = time.Now()
+		c.requests, c.failures, c.consecutive = 0, 0, 0
+	}
+	return transition
+}
+
+// circuitFailure reports whether err counts as a downstream failure, client
+// errors and cancellation by the caller do not.
+func circuitFailure(err error) bool {
+	var apiErr *APIError
+	if err == nil || errors.Is(err, context.Canceled) || !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Kind {
+	case KindClientError, KindDecode, KindEncode, KindAuth, KindCircuitOpen:
+		return false
+	}
+	return true
+}
+
+func (a *APIIntegration) allowCircuit(ctx context.Context) (circuitTicket, error) {
+	if a.Breaker == nil {
+		return circuitTicket{}, nil
+	}
+	ticket, allowed, transition := a.Breaker.allow(a.APICode)
+	a.logTransition(ctx, transition)
+	if !allowed {
+		a.writeLog(ctx, slog.LevelWarn, "failed send circuit open")
+		return ticket, a.newError(KindCircuitOpen, 0, nil, nil)
+	}
+	return ticket, nil
+}
+
+func (a *APIIntegration) doneCircuit(ctx context.Context, ticket circuitTicket, err error) {
+	if a.Breaker == nil {
+		return
+	}
+	a.logTransition(ctx, a.Breaker.done(a.APICode, ticket, circuitFailure(err)))
+}
+
+func (a *APIIntegration) logTransition(ctx context.Context, transition *circuitTransition) {
+	if transition == nil || transition.from == transition.to {
+		return
+	}
+	a.writeLog(ctx, slog.LevelWarn, "circuit state changed", "from", transition.from.String(), "to", transition.to.String())
+}
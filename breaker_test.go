@@ -0,0 +1,83 @@
+package apiintegration
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerIgnoresStaleCompletion(t *testing.T) {
+	b := NewCircuitBreaker(CircuitBreakerSettings{ConsecutiveFailures: 1, Cooldown: time.Millisecond})
+
+	// a slow call starts while closed
+	stale, allowed, _ := b.allow("A")
+	if !allowed {
+		t.Fatal("closed circuit refused a call")
+	}
+	// another call fails and opens the circuit
+	ticket, _, _ := b.allow("A")
+	b.done("A", ticket, true)
+	if state := b.State("A"); state != StateOpen {
+		t.Fatalf("state = %v, want open", state)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	probe, allowed, _ := b.allow("A")
+	if !allowed {
+		t.Fatal("half-open circuit refused the probe")
+	}
+
+	// the slow call succeeding must neither close the circuit nor free the probe
+	if transition := b.done("A", stale, false); transition != nil {
+		t.Fatalf("stale completion changed state %v -> %v", transition.from, transition.to)
+	}
+	if state := b.State("A"); state != StateHalfOpen {
+		t.Fatalf("state = %v, want half-open", state)
+	}
+	if _, allowed, _ := b.allow("A"); allowed {
+		t.Fatal("second probe allowed while the first is in flight")
+	}
+
+	b.done("A", probe, false)
+	if state := b.State("A"); state != StateClosed {
+		t.Fatalf("state = %v, want closed", state)
+	}
+}
+
+func TestCircuitBreakerFailedProbeReopens(t *testing.T) {
+	b := NewCircuitBreaker(CircuitBreakerSettings{ConsecutiveFailures: 1, Cooldown: time.Millisecond})
+	ticket, _, _ := b.allow("A")
+	b.done("A", ticket, true)
+
+	time.Sleep(2 * time.Millisecond)
+	probe, _, _ := b.allow("A")
+	b.done("A", probe, true)
+	if state := b.State("A"); state != StateOpen {
+		t.Fatalf("state = %v, want open", state)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if _, allowed, _ := b.allow("A"); !allowed {
+		t.Fatal("probe refused after cooldown, probes not reset")
+	}
+}
+
+func TestCircuitBreakerZeroValue(t *testing.T) {
+	b := &CircuitBreaker{}
+	if state := b.State("A"); state != StateClosed {
+		t.Fatalf("state = %v, want closed", state)
+	}
+	// the default settings open the circuit after 5 failures in a row
+	for i := 1; i <= defaultBreakerConsecutiveFailures; i++ {
+		ticket, allowed, _ := b.allow("A")
+		if !allowed {
+			t.Fatalf("call %d refused", i)
+		}
+		b.done("A", ticket, true)
+	}
+	if state := b.State("A"); state != StateOpen {
+		t.Fatalf("state = %v, want open", state)
+	}
+	if _, allowed, _ := b.allow("A"); allowed {
+		t.Fatal("open circuit allowed a call within the default cooldown")
+	}
+}
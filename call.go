@@ -0,0 +1,61 @@
+package apiintegration
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Call sends req as the ObjReq of a and decodes the response body into a Resp
+// with the ResponseDecoder registered for its Content-Type. Unless Headers
+// sets one, the Accept header lists every registered media type. A body that
+// cannot be decoded, or whose content type has no decoder, is returned as an
+// APIError of KindDecode. a itself is not modified, so it can be shared by
+// calls.
+func Call[Req, Resp any](ctx context.Context, a *APIIntegration, recorder ActivityRecorder, req Req) (Resp, error) {
+	var out Resp
+	err := a.call(ctx, recorder, req, &out, nil)
+	return out, err
+}
+
+// CallWithError is Call that also decodes the body of a failed response into
+// an E, available as a *E in the Envelope of the returned APIError.
+func CallWithError[Req, Resp, E any](ctx context.Context, a *APIIntegration, recorder ActivityRecorder, req Req) (Resp, error) {
+	var out Resp
+	err := a.call(ctx, recorder, req, &out, new(E))
+	return out, err
+}
+
+func (a *APIIntegration) call(ctx context.Context, recorder ActivityRecorder, req, out, envelope interface{}) error {
+	c := *a
+	c.ObjReq = req
+	c.Headers = make(map[string]string, len(a.Headers)+1)
+	c.Headers["Accept"] = acceptHeader()
+	for key, value := range a.Headers {
+		if http.CanonicalHeaderKey(key) == "Accept" {
+			delete(c.Headers, "Accept")
+		}
+		c.Headers[key] = value
+	}
+
+	resp, err := c.Do(ctx, recorder)
+	if err != nil {
+		var apiErr *APIError
+		if envelope != nil && resp != nil && len(resp.Body) > 0 && errors.As(err, &apiErr) {
+			if decodeResponse(resp.Header.Get("Content-Type"), resp.Body, envelope) == nil {
+				apiErr.Envelope = envelope
+			}
+		}
+		return err
+	}
+	if len(resp.Body) == 0 {
+		return nil
+	}
+
+	if err := decodeResponse(resp.Header.Get("Content-Type"), resp.Body, out); err != nil {
+		a.writeLog(ctx, slog.LevelError, "failed decode response body", "status", resp.StatusCode, "error", err.Error())
+		return a.newError(KindDecode, resp.StatusCode, resp.Body, err)
+	}
+	return nil
+}
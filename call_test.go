@@ -0,0 +1,139 @@
+package apiintegration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testOrder struct {
+	ID     int    `json:"id" xml:"id"`
+	Status string `json:"status" xml:"status"`
+}
+
+type testErrorEnvelope struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// callServer answers each path with the content type and status it names.
+func callServer(t *testing.T) (*httptest.Server, *string) {
+	var accept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		switch r.URL.Path {
+		case "/json":
+			var order testOrder
+			json.NewDecoder(r.Body).Decode(&order)
+			order.Status = "created"
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(order)
+		case "/xml":
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, `<order><id>7</id><status>created</status></order>`)
+		case "/error":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"code":"INVALID_AMOUNT","message":"amount must be positive"}`)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, `<html>maintenance</html>`)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &accept
+}
+
+func TestCall(t *testing.T) {
+	server, accept := callServer(t)
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodPost, ContentType: "application/json", Host: server.URL + "/json"}
+
+	order, err := Call[testOrder, testOrder](context.Background(), a, nil, testOrder{ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order != (testOrder{ID: 7, Status: "created"}) {
+		t.Errorf("order = %+v", order)
+	}
+	if *accept != acceptHeader() || *accept == "" {
+		t.Errorf("Accept = %q, want %q", *accept, acceptHeader())
+	}
+	if a.ObjReq != nil || a.Headers != nil {
+		t.Errorf("the integration was modified: %+v", a)
+	}
+
+	a.Host = server.URL + "/xml"
+	if order, err := Call[any, testOrder](context.Background(), a, nil, nil); err != nil || order != (testOrder{ID: 7, Status: "created"}) {
+		t.Errorf("xml order = %+v, %v", order, err)
+	}
+
+	a.Host = server.URL + "/empty"
+	if order, err := Call[any, testOrder](context.Background(), a, nil, nil); err != nil || order != (testOrder{}) {
+		t.Errorf("empty order = %+v, %v", order, err)
+	}
+}
+
+func TestCallAcceptHeaderOverride(t *testing.T) {
+	server, accept := callServer(t)
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: server.URL + "/xml", Headers: map[string]string{"accept": "application/xml"}}
+	if _, err := Call[any, testOrder](context.Background(), a, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if *accept != "application/xml" {
+		t.Errorf("Accept = %q, want application/xml", *accept)
+	}
+}
+
+func TestCallUnexpectedContentType(t *testing.T) {
+	server, _ := callServer(t)
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: server.URL + "/html"}
+	_, err := Call[any, testOrder](context.Background(), a, nil, nil)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != KindDecode || apiErr.StatusCode != http.StatusOK {
+		t.Fatalf("error = %v, want a KindDecode APIError", err)
+	}
+	if !errors.Is(err, ErrUnexpectedContentType) {
+		t.Errorf("error = %v, want ErrUnexpectedContentType", err)
+	}
+	if apiErr.Body != "<html>maintenance</html>" {
+		t.Errorf("body = %q", apiErr.Body)
+	}
+}
+
+func TestCallWithError(t *testing.T) {
+	server, _ := callServer(t)
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodPost, ContentType: "application/json", Host: server.URL + "/error"}
+	_, err := CallWithError[testOrder, testOrder, testErrorEnvelope](context.Background(), a, nil, testOrder{ID: -1})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != KindClientError || apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("error = %v, want a 422 APIError", err)
+	}
+	envelope, ok := apiErr.Envelope.(*testErrorEnvelope)
+	if !ok || envelope.Code != "INVALID_AMOUNT" || envelope.Message != "amount must be positive" {
+		t.Errorf("envelope = %#v", apiErr.Envelope)
+	}
+
+	// the envelope of a body that does not decode is left nil
+	a.Host = server.URL + "/html"
+	a.SuccessStatuses = []int{http.StatusCreated}
+	_, err = CallWithError[testOrder, testOrder, testErrorEnvelope](context.Background(), a, nil, testOrder{})
+	if !errors.As(err, &apiErr) || apiErr.Envelope != nil {
+		t.Errorf("error = %v, envelope = %#v", err, apiErr.Envelope)
+	}
+
+	// Call leaves the envelope nil
+	a.Host = server.URL + "/error"
+	a.SuccessStatuses = nil
+	_, err = Call[testOrder, testOrder](context.Background(), a, nil, testOrder{})
+	if !errors.As(err, &apiErr) || apiErr.Envelope != nil {
+		t.Errorf("error = %v, envelope = %#v", err, apiErr.Envelope)
+	}
+}
@@ -0,0 +1,253 @@
+package apiintegration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	cborUint = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// cborBreak ends an indefinite length item.
+const cborBreak = 0xff
+
+func decodeCBOR(data []byte, v interface{}) error {
+	r := &byteReader{data: data}
+	value, err := decodeCBORValue(r, 0)
+	if err != nil {
+		return fmt.Errorf("cbor: %v", err)
+	}
+	if r.remaining() > 0 {
+		return errors.New("cbor: trailing data")
+	}
+	return decodeVia(value, v)
+}
+
+func decodeCBORValue(r *byteReader, depth int) (interface{}, error) {
+	if depth > maxDecodeDepth {
+		return nil, errors.New("max depth exceeded")
+	}
+	b, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if b == cborBreak {
+		return nil, errors.New("unexpected break")
+	}
+	major, info := b>>5, b&0x1f
+
+	if major == cborSimple {
+		return decodeCBORSimple(r, info)
+	}
+	if info == 31 {
+		return decodeCBORIndefinite(r, major, depth)
+	}
+	n, err := decodeCBORArgument(r, info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return -1 - float64(n), nil
+		}
+		return -1 - int64(n), nil
+	case cborBytes:
+		data, err := r.next(n)
+		return append([]byte(nil), data...), err
+	case cborText:
+		data, err := r.next(n)
+		return string(data), err
+	case cborArray:
+		if n > uint64(r.remaining()) {
+			return nil, errors.New("array length exceeds data")
+		}
+		values := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			value, err := decodeCBORValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case cborMap:
+		if n > uint64(r.remaining()) {
+			return nil, errors.New("map length exceeds data")
+		}
+		values := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			if err := decodeCBORPair(r, values, depth); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case cborTag:
+		return decodeCBORTag(r, n, depth)
+	}
+	return nil, fmt.Errorf("unsupported major type %d", major)
+}
+
+func decodeCBORArgument(r *byteReader, info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		return r.uint(1 << (info - 24))
+	}
+	return 0, fmt.Errorf("invalid additional information %d", info)
+}
+
+func decodeCBORPair(r *byteReader, values map[string]interface{}, depth int) error {
+	key, err := decodeCBORValue(r, depth+1)
+	if err != nil {
+		return err
+	}
+	value, err := decodeCBORValue(r, depth+1)
+	if err != nil {
+		return err
+	}
+	values[mapKey(key)] = value
+	return nil
+}
+
+func decodeCBORIndefinite(r *byteReader, major byte, depth int) (interface{}, error) {
+	switch major {
+	case cborBytes, cborText:
+		var data []byte
+		for !cborEndOfIndefinite(r) {
+			// chunks are definite length strings of the same major type
+			if r.remaining() > 0 && (r.data[r.pos]>>5 != major || r.data[r.pos]&0x1f == 31) {
+				return nil, errors.New("invalid indefinite length chunk")
+			}
+			chunk, err := decodeCBORValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			switch c := chunk.(type) {
+			case []byte:
+				data = append(data, c...)
+			case string:
+				data = append(data, c...)
+			}
+		}
+		if major == cborText {
+			return string(data), nil
+		}
+		return data, nil
+	case cborArray:
+		values := []interface{}{}
+		for !cborEndOfIndefinite(r) {
+			value, err := decodeCBORValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case cborMap:
+		values := map[string]interface{}{}
+		for !cborEndOfIndefinite(r) {
+			if err := decodeCBORPair(r, values, depth); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("invalid indefinite length major type %d", major)
+}
+
+// cborEndOfIndefinite consumes the break ending the indefinite length item
+// being decoded, only the item itself may consume it.
+func cborEndOfIndefinite(r *byteReader) bool {
+	if r.remaining() > 0 && r.data[r.pos] == cborBreak {
+		r.pos++
+		return true
+	}
+	return false
+}
+
+func decodeCBORSimple(r *byteReader, info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		n, err := r.uint(2)
+		return halfToFloat64(uint16(n)), err
+	case 26:
+		n, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 27:
+		n, err := r.uint(8)
+		return math.Float64frombits(n), err
+	}
+	return nil, fmt.Errorf("unsupported simple value %d", info)
+}
+
+// decodeCBORTag decodes tagged content, date/time tags become time.Time and
+// other tags are ignored.
+func decodeCBORTag(r *byteReader, tag uint64, depth int) (interface{}, error) {
+	value, err := decodeCBORValue(r, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case 0:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("invalid date/time string")
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case 1:
+		switch epoch := value.(type) {
+		case uint64:
+			return time.Unix(int64(epoch), 0).UTC(), nil
+		case int64:
+			return time.Unix(epoch, 0).UTC(), nil
+		case float64:
+			sec, frac := math.Modf(epoch)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, errors.New("invalid epoch date/time")
+	}
+	return value, nil
+}
+
+func halfToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,175 @@
+package apiintegration
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hexData(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The vectors come from RFC 8949, appendix A. Values decoded into an
+// interface{} follow the JSON rules: numbers are float64.
+func TestCBORDecoderVectors(t *testing.T) {
+	tests := []struct {
+		data string
+		want interface{}
+	}{
+		{"00", float64(0)},
+		{"17", float64(23)},
+		{"1818", float64(24)},
+		{"1903e8", float64(1000)},
+		{"20", float64(-1)},
+		{"3863", float64(-100)},
+		{"f90000", float64(0)},
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"6161", "a"},
+		{"62c3bc", "ü"},
+		{"83010203", []interface{}{1.0, 2.0, 3.0}},
+		{"8301820203820405", []interface{}{1.0, []interface{}{2.0, 3.0}, []interface{}{4.0, 5.0}}},
+		{"a201020304", map[string]interface{}{"1": 2.0, "3": 4.0}},
+		{"a26161016162820203", map[string]interface{}{"a": 1.0, "b": []interface{}{2.0, 3.0}}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9fff", []interface{}{}},
+		{"9f018202039f0405ffff", []interface{}{1.0, []interface{}{2.0, 3.0}, []interface{}{4.0, 5.0}}},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": 1.0, "b": []interface{}{2.0, 3.0}}},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+		{"c11a514b67b0", "2013-03-21T20:04:00Z"},
+		{"d74401020304", "AQIDBA=="},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		if err := CBORDecoder.Decode(hexData(t, tt.data), &got); err != nil {
+			t.Errorf("%s: %v", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestCBORDecoderTypedVectors(t *testing.T) {
+	var max uint64
+	if err := CBORDecoder.Decode(hexData(t, "1bffffffffffffffff"), &max); err != nil || max != math.MaxUint64 {
+		t.Errorf("uint64 = %d, %v", max, err)
+	}
+
+	var b []byte
+	if err := CBORDecoder.Decode(hexData(t, "5f42010243030405ff"), &b); err != nil || !bytes.Equal(b, []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("indefinite bytes = %x, %v", b, err)
+	}
+
+	var date time.Time
+	want := time.Date(2013, 3, 21, 20, 4, 0, 500000000, time.UTC)
+	if err := CBORDecoder.Decode(hexData(t, "c1fb41d452d9ec200000"), &date); err != nil || !date.Equal(want) {
+		t.Errorf("epoch float date = %v, %v", date, err)
+	}
+
+	var out struct {
+		A float64 `json:"a"`
+		B []int
+	}
+	if err := CBORDecoder.Decode(hexData(t, "a26161fa3fc000006162820203"), &out); err != nil || out.A != 1.5 || !reflect.DeepEqual(out.B, []int{2, 3}) {
+		t.Errorf("struct = %+v, %v", out, err)
+	}
+}
+
+func TestCBORDecoderNonFinite(t *testing.T) {
+	var inf, negInf, nan float64
+	if err := CBORDecoder.Decode(hexData(t, "f97c00"), &inf); err != nil || !math.IsInf(inf, 1) {
+		t.Errorf("Infinity = %v, %v", inf, err)
+	}
+	if err := CBORDecoder.Decode(hexData(t, "f9fc00"), &negInf); err != nil || !math.IsInf(negInf, -1) {
+		t.Errorf("-Infinity = %v, %v", negInf, err)
+	}
+	if err := CBORDecoder.Decode(hexData(t, "fb7ff8000000000000"), &nan); err != nil || !math.IsNaN(nan) {
+		t.Errorf("NaN = %v, %v", nan, err)
+	}
+
+	// {"a": [1, NaN], "b": Infinity}
+	data := hexData(t, "a2616182f93c00f97e006162f97c00")
+	var got interface{}
+	if err := CBORDecoder.Decode(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	m := got.(map[string]interface{})
+	if a := m["a"].([]interface{}); a[0] != 1.0 || !math.IsNaN(a[1].(float64)) || !math.IsInf(m["b"].(float64), 1) {
+		t.Errorf("interface = %#v", got)
+	}
+
+	var out struct {
+		A []float32
+		B *float64 `json:"b"`
+	}
+	if err := CBORDecoder.Decode(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.A) != 2 || out.A[0] != 1 || !math.IsNaN(float64(out.A[1])) || out.B == nil || !math.IsInf(*out.B, 1) {
+		t.Errorf("struct = %+v", out)
+	}
+
+	var byKey map[string]float64
+	if err := CBORDecoder.Decode(hexData(t, "a16162f97c00"), &byKey); err != nil || !math.IsInf(byKey["b"], 1) {
+		t.Errorf("map = %v, %v", byKey, err)
+	}
+
+	var notFloat struct{ B int }
+	if err := CBORDecoder.Decode(hexData(t, "a16162f97c00"), &notFloat); err == nil {
+		t.Error("Infinity decoded into an int")
+	}
+}
+
+func TestCBORDecoderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"lone break", "ff"},
+		{"break inside definite array inside indefinite array", "9f81ff"},
+		{"break inside definite map", "a1ff"},
+		{"break as definite array item", "82 01 ff"},
+		{"missing break", "9f01"},
+		{"missing break in map", "bf616101"},
+		{"truncated array", "83 01 02"},
+		{"truncated string", "63 6161"},
+		{"truncated argument", "19 03"},
+		{"reserved additional information", "1c"},
+		{"indefinite integer", "1f"},
+		{"integer chunk in indefinite bytes", "5f 01 ff"},
+		{"text chunk in indefinite bytes", "5f 6161 ff"},
+		{"nested indefinite chunk", "7f 7f ff ff"},
+		{"array length exceeds data", "9b ffffffffffffffff"},
+		{"map length exceeds data", "ba ffffffff"},
+		{"trailing data", "00 00"},
+		{"unsupported simple value", "f8 20"},
+		{"invalid date string", "c0 01"},
+		{"invalid epoch", "c1 6161"},
+		{"too deep", strings.Repeat("81", maxDecodeDepth+2) + "00"},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		if err := CBORDecoder.Decode(hexData(t, tt.data), &got); err == nil {
+			t.Errorf("%s: %s decoded as %#v", tt.name, tt.data, got)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package apiintegration
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// defaultClient is used by every APIIntegration without a Client.
+var defaultClient = NewClient(ClientOptions{})
+
+// ClientOptions configures the transport of a Client, zero fields fall back
+// to the defaults noted on each field.
+type ClientOptions struct {
+	// MaxIdleConns bounds the idle connections kept across hosts, default
+	// 100.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept per host,
+	// default 10.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the connections per host, zero means no limit.
+	MaxConnsPerHost int
+	// IdleConnTimeout closes connections idle for that long, default 90
+	// seconds.
+	IdleConnTimeout time.Duration
+	// DisableHTTP2 restricts the client to HTTP/1.1.
+	DisableHTTP2 bool
+	// H2C sends http:// requests over unencrypted HTTP/2 with prior
+	// knowledge, for local APIs served by h2c. Such a client only speaks
+	// HTTP/2, also to https:// hosts.
+	H2C             bool
+	TLSClientConfig *tls.Config
+}
+
+// Client owns the connection pool shared by the APIIntegration calls made
+// with it, and the middlewares wrapping each of their requests. A Client is
+// safe for concurrent use and should be reused. The zero Client sends through
+// the pool shared by the package, with its own middlewares.
+type Client struct {
+	transport *http.Transport
+	dialer    *net.Dialer
+
+	mu          sync.Mutex
+	middlewares []Middleware
+
+	dials    int64
+	open     int64
+	requests int64
+	inFlight int64
+	reused   int64
+	idleHits int64
+}
+
+// PoolStats is a snapshot of the connection pool usage of a Client.
+type PoolStats struct {
+	// Dials is the number of connections dialed.
+	Dials int64
+	// OpenConns is the number of connections currently open, idle or not.
+	OpenConns int64
+	// Requests is the number of requests sent.
+	Requests int64
+	// InFlight is the number of requests whose response is not done yet.
+	InFlight int64
+	// ReusedConns is the number of requests sent on a reused connection.
+	ReusedConns int64
+	// IdleHits is the number of requests sent on a connection taken from
+	// the idle pool.
+	IdleHits int64
+}
+
+// NewClient returns a Client with its own connection pool configured by opts.
+func NewClient(opts ClientOptions) *Client {
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = defaultMaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = defaultIdleConnTimeout
+	}
+
+	// the dial and TLS handshake timeouts are the phase timeouts of each call
+	c := &Client{
+		dialer: &net.Dialer{KeepAlive: 30 * time.Second},
+	}
+	protocols := new(http.Protocols)
+	switch {
+	case opts.H2C:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	case opts.DisableHTTP2:
+		protocols.SetHTTP1(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	c.transport = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           c.dial,
+		ForceAttemptHTTP2:     !opts.DisableHTTP2,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsClientConfig(opts),
+		Protocols:             protocols,
+	}
+	return c
+}
+
+// tlsClientConfig returns a copy of the TLSClientConfig of opts, which the
+// transport adds its protocols to, without "h2" when HTTP/2 is disabled.
+func tlsClientConfig(opts ClientOptions) *tls.Config {
+	if opts.TLSClientConfig == nil {
+		return nil
+	}
+	config := opts.TLSClientConfig.Clone()
+	if opts.DisableHTTP2 {
+		var protos []string
+		for _, proto := range config.NextProtos {
+			if proto != "h2" {
+				protos = append(protos, proto)
+			}
+		}
+		config.NextProtos = protos
+	}
+	return config
+}
+
+// pool returns the Client owning the connection pool of c, the shared one
+// for the zero Client.
+func (c *Client) pool() *Client {
+	if c.transport == nil {
+		return defaultClient
+	}
+	return c
+}
+
+// Stats returns the current connection pool usage of c.
+func (c *Client) Stats() PoolStats {
+	c = c.pool()
+	return PoolStats{
+		Dials:       atomic.LoadInt64(&c.dials),
+		OpenConns:   atomic.LoadInt64(&c.open),
+		Requests:    atomic.LoadInt64(&c.requests),
+		InFlight:    atomic.LoadInt64(&c.inFlight),
+		ReusedConns: atomic.LoadInt64(&c.reused),
+		IdleHits:    atomic.LoadInt64(&c.idleHits),
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the pool of c.
+func (c *Client) CloseIdleConnections() {
+	c.pool().transport.CloseIdleConnections()
+}
+
+// httpClient returns an http.Client sending through the pool of c.
+func (c *Client) httpClient(timeout time.Duration) http.Client {
+	return http.Client{Transport: c, Timeout: timeout}
+}
+
+// RoundTrip implements http.RoundTripper on the pool of c, through its
+// middlewares.
+func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
+	return c.chain()(req)
+}
+
+// roundTrip sends req on the pool of c.
+func (c *Client) roundTrip(req *http.Request) (*http.Response, error) {
+	c = c.pool()
+	atomic.AddInt64(&c.requests, 1)
+	atomic.AddInt64(&c.inFlight, 1)
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&c.reused, 1)
+			}
+			if info.WasIdle {
+				atomic.AddInt64(&c.idleHits, 1)
+			}
+		},
+	}
+	response, err := c.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		atomic.AddInt64(&c.inFlight, -1)
+		return nil, err
+	}
+	response.Body = &doneBody{ReadCloser: response.Body, done: func() { atomic.AddInt64(&c.inFlight, -1) }}
+	return response, nil
+}
+
+func (c *Client) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := c.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.dials, 1)
+	atomic.AddInt64(&c.open, 1)
+	return &countedConn{Conn: conn, client: c}, nil
+}
+
+// countedConn keeps the open connections count of its Client.
+type countedConn struct {
+	net.Conn
+	client *Client
+	once   sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.client.open, -1) })
+	return c.Conn.Close()
+}
+
+// doneBody calls done once, when the body is closed.
+type doneBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *doneBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
+
+func (a *APIIntegration) client() *Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return defaultClient
+}
@@ -0,0 +1,112 @@
+package apiintegration
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// protoServer answers the protocol each request was served with.
+func protoServer() *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	return server
+}
+
+func sendProto(t *testing.T, client *Client, url string) string {
+	t.Helper()
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: url, Client: client}
+	resp, err := a.Do(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(resp.Body)
+}
+
+func TestClientStats(t *testing.T) {
+	server := protoServer()
+	server.Start()
+	defer server.Close()
+
+	client := NewClient(ClientOptions{})
+	for i := 0; i < 3; i++ {
+		sendProto(t, client, server.URL)
+	}
+	stats := client.Stats()
+	want := PoolStats{Dials: 1, OpenConns: 1, Requests: 3, InFlight: 0, ReusedConns: 2, IdleHits: 2}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	client.CloseIdleConnections()
+	if open := client.Stats().OpenConns; open != 0 {
+		t.Errorf("open connections after closing the idle ones = %d, want 0", open)
+	}
+}
+
+func TestClientH2C(t *testing.T) {
+	server := protoServer()
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	if proto := sendProto(t, NewClient(ClientOptions{H2C: true}), server.URL); proto != "HTTP/2.0" {
+		t.Errorf("proto = %s, want HTTP/2.0", proto)
+	}
+	if proto := sendProto(t, NewClient(ClientOptions{}), server.URL); proto != "HTTP/1.1" {
+		t.Errorf("proto without H2C = %s, want HTTP/1.1", proto)
+	}
+}
+
+func TestClientDisableHTTP2(t *testing.T) {
+	server := protoServer()
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	// a config offering h2, such as one shared with an HTTP/2 transport
+	config := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	config.NextProtos = []string{"h2", "http/1.1"}
+
+	if proto := sendProto(t, NewClient(ClientOptions{TLSClientConfig: config}), server.URL); proto != "HTTP/2.0" {
+		t.Errorf("proto = %s, want HTTP/2.0", proto)
+	}
+	if proto := sendProto(t, NewClient(ClientOptions{TLSClientConfig: config, DisableHTTP2: true}), server.URL); proto != "HTTP/1.1" {
+		t.Errorf("proto with DisableHTTP2 = %s, want HTTP/1.1", proto)
+	}
+	if len(config.NextProtos) != 2 {
+		t.Errorf("the caller config was modified: %v", config.NextProtos)
+	}
+}
+
+func TestZeroClient(t *testing.T) {
+	server := protoServer()
+	server.Start()
+	defer server.Close()
+
+	client := &Client{}
+	var used bool
+	client.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			used = true
+			return next(req)
+		}
+	})
+	before := defaultClient.Stats().Requests
+	if proto := sendProto(t, client, server.URL); proto != "HTTP/1.1" {
+		t.Errorf("proto = %s", proto)
+	}
+	if !used {
+		t.Error("the middleware of the zero client was not used")
+	}
+	if requests := client.Stats().Requests; requests != before+1 {
+		t.Errorf("requests of the shared pool = %d, want %d", requests, before+1)
+	}
+}
@@ -0,0 +1,38 @@
+package apiintegration
+
+import "context"
+
+type callInfoKey struct{}
+
+// callInfo describes the attempt of the integration call an Authenticator or
+// a Middleware runs for.
+type callInfo struct {
+	UserID     int64
+	Token      string
+	APICode    string
+	Attempt    int
+	IsLocalAPI bool
+}
+
+func withCallInfo(ctx context.Context, a *APIIntegration, attempt int) context.Context {
+	return context.WithValue(ctx, callInfoKey{}, callInfo{UserID: a.UserID, Token: a.Token, APICode: a.APICode, Attempt: attempt, IsLocalAPI: a.IsLocalAPI})
+}
+
+func callInfoFrom(ctx context.Context) (callInfo, bool) {
+	info, ok := ctx.Value(callInfoKey{}).(callInfo)
+	return info, ok
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation ID of the
+// inbound request, sent and recorded with every call made with the context.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID carried by ctx.
+func CorrelationID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey{}).(string)
+	return id, ok && id != ""
+}
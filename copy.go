@@ -0,0 +1,84 @@
+package apiintegration
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var activityColumns = []string{"ac_user_id", "ac_token", "ac_api_date", "ac_api_name", "ac_request", "ac_error_request", "ac_response", "ac_error_response", "ac_correlation_id", "ac_attempt", "ac_created_by", "ac_created_at"}
+
+// CopyRecorder records batches of activities in the at_api_activity table with
+// the Postgres COPY protocol, falling back to row inserts when COPY fails.
+type CopyRecorder struct {
+	SQLRecorder
+	// Logger logs the fallbacks to row inserts, nil logs nothing.
+	Logger Logger
+
+	// table is copied into instead of at_api_activity, tests point it at a
+	// missing table to force the fallback.
+	table string
+}
+
+func NewCopyRecorder(db *sqlx.DB) *CopyRecorder {
+	return &CopyRecorder{SQLRecorder: SQLRecorder{DB: db}}
+}
+
+// NewPostgresRecorder returns an AsyncRecorder writing its batches with a
+// CopyRecorder, settings sets the batch size, the flush interval and the
+// logger of both.
+func NewPostgresRecorder(db *sqlx.DB, settings AsyncRecorderSettings) *AsyncRecorder {
+	recorder := NewCopyRecorder(db)
+	recorder.Logger = settings.Logger
+	return NewAsyncRecorder(recorder, settings)
+}
+
+// RecordBatch copies activities in a single transaction.
+func (r *CopyRecorder) RecordBatch(ctx context.Context, activities []Activity) error {
+	if err := r.copyBatch(ctx, activities); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		if r.Logger != nil {
+			r.Logger.Log(ctx, slog.LevelWarn, "failed copy api activity, fallback to insert", "activities", len(activities), "error", err.Error())
+		}
+		return r.SQLRecorder.RecordBatch(ctx, activities)
+	}
+	return nil
+}
+
+func (r *CopyRecorder) copyBatch(ctx context.Context, activities []Activity) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	table := r.table
+	if table == "" {
+		table = "at_api_activity"
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, activityColumns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	createdAt := time.Now()
+	for _, activity := range activities {
+		if _, err := stmt.ExecContext(ctx, append(activity.insertArgs(), createdAt)...); err != nil {
+			return err
+		}
+	}
+	// flush the copied rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,123 @@
+package apiintegration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// testDB connects to the Postgres of APIINTEGRATION_TEST_DSN and creates a
+// temporary at_api_activity table shadowing the real one, it skips tb when
+// the variable is unset.
+func testDB(tb testing.TB) *sqlx.DB {
+	dsn := os.Getenv("APIINTEGRATION_TEST_DSN")
+	if dsn == "" {
+		tb.Skip("APIINTEGRATION_TEST_DSN is not set")
+	}
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	// the temporary table lives in a single session
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TEMPORARY TABLE at_api_activity (
+		id bigserial PRIMARY KEY,
+		ac_user_id bigint,
+		ac_token text,
+		ac_api_date timestamptz,
+		ac_api_name text,
+		ac_request text,
+		ac_error_request text,
+		ac_response text,
+		ac_error_response text,
+		ac_correlation_id varchar(64),
+		ac_attempt integer NOT NULL DEFAULT 1,
+		ac_created_by bigint,
+		ac_created_at timestamptz
+	)`)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+func testActivities(n int) []Activity {
+	activities := make([]Activity, n)
+	for i := range activities {
+		activities[i] = Activity{
+			UserID:        42,
+			Token:         "[REDACTED]",
+			APIName:       "TEST",
+			Attempt:       1,
+			Date:          time.Now(),
+			Request:       "POST /v1/payments HTTP/1.1\r\nHost: api.example.com\r\n\r\n{\"amount\":100}",
+			Response:      "HTTP/1.1 200 OK\r\n\r\n{\"id\":1}",
+			CorrelationID: fmt.Sprintf("req-%d", i),
+		}
+	}
+	return activities
+}
+
+func countActivities(tb testing.TB, db *sqlx.DB) int {
+	var n int
+	if err := db.Get(&n, "SELECT count(*) FROM at_api_activity"); err != nil {
+		tb.Fatal(err)
+	}
+	return n
+}
+
+func TestCopyRecorder(t *testing.T) {
+	db := testDB(t)
+	r := NewCopyRecorder(db)
+	if err := r.RecordBatch(context.Background(), testActivities(3)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countActivities(t, db); n != 3 {
+		t.Errorf("recorded %d activities, want 3", n)
+	}
+	activities, err := r.FindAPIActivityByCorrelationID(context.Background(), "req-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 || activities[0].APIName != "TEST" || activities[0].Attempt != 1 {
+		t.Errorf("found %+v", activities)
+	}
+}
+
+func TestCopyRecorderFallsBackToInsert(t *testing.T) {
+	db := testDB(t)
+	r := NewCopyRecorder(db)
+	r.table = "at_api_activity_missing"
+	if err := r.RecordBatch(context.Background(), testActivities(3)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countActivities(t, db); n != 3 {
+		t.Errorf("recorded %d activities, want 3", n)
+	}
+}
+
+func benchmarkBatchRecorder(b *testing.B, recorder func(db *sqlx.DB) BatchRecorder) {
+	db := testDB(b)
+	r := recorder(db)
+	activities := testActivities(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := r.RecordBatch(context.Background(), activities); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(activities))/b.Elapsed().Seconds(), "activities/s")
+}
+
+func BenchmarkCopyRecorder(b *testing.B) {
+	benchmarkBatchRecorder(b, func(db *sqlx.DB) BatchRecorder { return NewCopyRecorder(db) })
+}
+
+func BenchmarkSQLRecorder(b *testing.B) {
+	benchmarkBatchRecorder(b, func(db *sqlx.DB) BatchRecorder { return NewSQLRecorder(db) })
+}
@@ -0,0 +1,90 @@
+package apiintegration
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultCorrelationHeader = "X-Request-ID"
+	traceparentHeader        = "traceparent"
+)
+
+// NewCorrelationID returns a random correlation ID of 32 hex digits, which is
+// also a valid W3C trace ID.
+func NewCorrelationID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// CorrelationIDFromRequest returns the correlation ID of an inbound request,
+// from its X-Request-ID header or the trace ID of its traceparent header.
+func CorrelationIDFromRequest(r *http.Request) (string, bool) {
+	if id := r.Header.Get(defaultCorrelationHeader); id != "" {
+		return id, true
+	}
+	parts := strings.Split(r.Header.Get(traceparentHeader), "-")
+	if len(parts) == 4 && isTraceID(parts[1]) {
+		return parts[1], true
+	}
+	return "", false
+}
+
+// withCorrelationID returns ctx carrying its correlation ID, a new one when
+// it carries none.
+func withCorrelationID(ctx context.Context) context.Context {
+	if _, ok := CorrelationID(ctx); ok {
+		return ctx
+	}
+	return WithCorrelationID(ctx, NewCorrelationID())
+}
+
+func (a *APIIntegration) correlationHeader() string {
+	if a.CorrelationHeader == "" {
+		return defaultCorrelationHeader
+	}
+	return a.CorrelationHeader
+}
+
+// setCorrelationHeader sends the correlation ID of ctx with req. A traceparent
+// header carries it as trace ID, hashed into one when it is not, with a new
+// parent ID for each attempt.
+func (a *APIIntegration) setCorrelationHeader(ctx context.Context, req *http.Request) {
+	id, ok := CorrelationID(ctx)
+	if !ok {
+		return
+	}
+	header := a.correlationHeader()
+	if !strings.EqualFold(header, traceparentHeader) {
+		req.Header.Set(header, id)
+		return
+	}
+
+	traceID := strings.ToLower(id)
+	if !isTraceID(traceID) {
+		sum := sha256.Sum256([]byte(id))
+		traceID = hex.EncodeToString(sum[:16])
+	}
+	parentID := make([]byte, 8)
+	rand.Read(parentID)
+	req.Header.Set(traceparentHeader, "00-"+traceID+"-"+hex.EncodeToString(parentID)+"-01")
+}
+
+// isTraceID reports whether id is a valid W3C trace ID: 32 lower case hex
+// digits, not all zero.
+func isTraceID(id string) bool {
+	if len(id) != 32 || id == strings.Repeat("0", 32) {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
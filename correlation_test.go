@@ -0,0 +1,159 @@
+package apiintegration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-01$`)
+
+// headerServer records the header it names of each request and fails the
+// first failures of them.
+func headerServer(t *testing.T, name string, failures int) (*httptest.Server, func() []string) {
+	var (
+		mu     sync.Mutex
+		values []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		values = append(values, r.Header.Get(name))
+		fail := len(values) <= failures
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), values...)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		ctx    context.Context
+		sent   string
+	}{
+		{"from context", "", WithCorrelationID(context.Background(), "req-1"), "req-1"},
+		{"custom header", "X-Correlation-ID", WithCorrelationID(context.Background(), "req-1"), "req-1"},
+		{"generated", "", context.Background(), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sentHeader := tt.header
+			if sentHeader == "" {
+				sentHeader = defaultCorrelationHeader
+			}
+			server, sent := headerServer(t, sentHeader, 0)
+			recorder := &MemoryRecorder{}
+			a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: server.URL, CorrelationHeader: tt.header}
+			if _, err := a.Do(tt.ctx, recorder); err != nil {
+				t.Fatal(err)
+			}
+
+			activities := recorder.Activities()
+			if len(activities) != 1 {
+				t.Fatalf("recorded %d activities", len(activities))
+			}
+			id := activities[0].CorrelationID
+			if tt.sent == "" {
+				if !isTraceID(id) {
+					t.Errorf("generated ID = %q, want 32 hex digits", id)
+				}
+			} else if id != tt.sent {
+				t.Errorf("recorded ID = %q, want %q", id, tt.sent)
+			}
+			if got := sent(); len(got) != 1 || got[0] != id {
+				t.Errorf("%s = %q, want %q", sentHeader, got, id)
+			}
+		})
+	}
+}
+
+func TestCorrelationTraceparent(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const zeroID = "00000000000000000000000000000000"
+	sum := sha256.Sum256([]byte("req-1"))
+	zeroSum := sha256.Sum256([]byte(zeroID))
+	tests := []struct {
+		name  string
+		id    string
+		trace string
+	}{
+		{"trace ID", traceID, traceID},
+		{"upper case trace ID", "4BF92F3577B34DA6A3CE929D0E0E4736", traceID},
+		{"hashed", "req-1", hex.EncodeToString(sum[:16])},
+		{"all zero hashed", zeroID, hex.EncodeToString(zeroSum[:16])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, sent := headerServer(t, traceparentHeader, 1)
+			recorder := &MemoryRecorder{}
+			a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: server.URL, CorrelationHeader: "Traceparent",
+				Retry: &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}}
+			if _, err := a.Do(WithCorrelationID(context.Background(), tt.id), recorder); err != nil {
+				t.Fatal(err)
+			}
+
+			headers := sent()
+			if len(headers) != 2 {
+				t.Fatalf("sent %d requests", len(headers))
+			}
+			var parents []string
+			for _, header := range headers {
+				match := traceparentPattern.FindStringSubmatch(header)
+				if match == nil {
+					t.Fatalf("traceparent = %q", header)
+				}
+				if match[1] != tt.trace {
+					t.Errorf("trace ID = %q, want %q", match[1], tt.trace)
+				}
+				parents = append(parents, match[2])
+			}
+			if parents[0] == parents[1] {
+				t.Errorf("both attempts sent parent ID %q", parents[0])
+			}
+			for _, activity := range recorder.Activities() {
+				if activity.CorrelationID != tt.id {
+					t.Errorf("recorded ID = %q, want the raw %q", activity.CorrelationID, tt.id)
+				}
+			}
+		})
+	}
+}
+
+func TestCorrelationIDFromRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		id     string
+		ok     bool
+	}{
+		{"request ID", http.Header{"X-Request-Id": {"req-1"}}, "req-1", true},
+		{"request ID first", http.Header{"X-Request-Id": {"req-1"}, "Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}, "req-1", true},
+		{"traceparent", http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}, "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"invalid traceparent", http.Header{"Traceparent": {"00-00000000000000000000000000000000-00f067aa0ba902b7-01"}}, "", false},
+		{"malformed traceparent", http.Header{"Traceparent": {"4bf92f3577b34da6a3ce929d0e0e4736"}}, "", false},
+		{"none", http.Header{}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tt.header
+			if id, ok := CorrelationIDFromRequest(r); id != tt.id || ok != tt.ok {
+				t.Errorf("CorrelationIDFromRequest() = %q, %v, want %q, %v", id, ok, tt.id, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package apiintegration
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrUnexpectedContentType is wrapped by the KindDecode APIError returned when
+// a response has a content type without a registered ResponseDecoder.
+var ErrUnexpectedContentType = errors.New("unexpected content type")
+
+// ResponseDecoder decodes a response body into v.
+type ResponseDecoder interface {
+	Decode(data []byte, v interface{}) error
+}
+
+// ResponseDecoderFunc adapts a function to a ResponseDecoder.
+type ResponseDecoderFunc func(data []byte, v interface{}) error
+
+func (f ResponseDecoderFunc) Decode(data []byte, v interface{}) error {
+	return f(data, v)
+}
+
+var (
+	// JSONDecoder decodes with json.Unmarshal.
+	JSONDecoder ResponseDecoder = ResponseDecoderFunc(json.Unmarshal)
+	// XMLDecoder decodes with xml.Unmarshal.
+	XMLDecoder ResponseDecoder = ResponseDecoderFunc(xml.Unmarshal)
+	// MessagePackDecoder decodes MessagePack into v by way of its JSON
+	// form, so v is filled following its json tags.
+	MessagePackDecoder ResponseDecoder = ResponseDecoderFunc(decodeMessagePack)
+	// CBORDecoder decodes CBOR into v by way of its JSON form, so v is
+	// filled following its json tags.
+	CBORDecoder ResponseDecoder = ResponseDecoderFunc(decodeCBOR)
+)
+
+type registeredDecoder struct {
+	mediaType string
+	decoder   ResponseDecoder
+}
+
+var (
+	responseDecodersMu sync.RWMutex
+	responseDecoders   = []registeredDecoder{
+		{"application/json", JSONDecoder},
+		{"application/xml", XMLDecoder},
+		{"text/xml", XMLDecoder},
+		{"application/msgpack", MessagePackDecoder},
+		{"application/x-msgpack", MessagePackDecoder},
+		{"application/vnd.msgpack", MessagePackDecoder},
+		{"application/cbor", CBORDecoder},
+	}
+)
+
+// RegisterResponseDecoder sets the ResponseDecoder used for responses of
+// mediaType, replacing any previous one. Registered media types are listed in
+// the Accept header of typed calls in registration order.
+func RegisterResponseDecoder(mediaType string, decoder ResponseDecoder) {
+	responseDecodersMu.Lock()
+	defer responseDecodersMu.Unlock()
+
+	mediaType = strings.ToLower(mediaType)
+	for i, registered := range responseDecoders {
+		if registered.mediaType == mediaType {
+			responseDecoders[i].decoder = decoder
+			return
+		}
+	}
+	responseDecoders = append(responseDecoders, registeredDecoder{mediaType, decoder})
+}
+
+// acceptHeader lists the media types of the registered response decoders.
+func acceptHeader() string {
+	responseDecodersMu.RLock()
+	defer responseDecodersMu.RUnlock()
+
+	mediaTypes := make([]string, 0, len(responseDecoders))
+	for _, registered := range responseDecoders {
+		mediaTypes = append(mediaTypes, registered.mediaType)
+	}
+	return strings.Join(mediaTypes, ", ")
+}
+
+// responseDecoderFor returns the ResponseDecoder registered for contentType,
+// falling back on the +json and +xml structured syntax suffixes. A response
+// without content type is decoded as JSON.
+func responseDecoderFor(contentType string) (ResponseDecoder, error) {
+	if contentType == "" {
+		return JSONDecoder, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrUnexpectedContentType, contentType, err)
+	}
+
+	responseDecodersMu.RLock()
+	defer responseDecodersMu.RUnlock()
+	for _, registered := range responseDecoders {
+		if registered.mediaType == mediaType {
+			return registered.decoder, nil
+		}
+	}
+	switch {
+	case strings.HasSuffix(mediaType, "+json"):
+		return JSONDecoder, nil
+	case strings.HasSuffix(mediaType, "+xml"):
+		return XMLDecoder, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnexpectedContentType, contentType)
+}
+
+// decodeResponse decodes body according to its content type.
+func decodeResponse(contentType string, body []byte, v interface{}) error {
+	decoder, err := responseDecoderFor(contentType)
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(body, v)
+}
+
+// decodeVia fills v from a value decoded by a non JSON decoder, by way of its
+// JSON form. NaN and infinite floats, which JSON cannot carry, are decoded as
+// null then set on v.
+func decodeVia(value interface{}, v interface{}) error {
+	var nonFinite []nonFiniteFloat
+	value = withoutNonFinite(value, nil, &nonFinite)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	for _, f := range nonFinite {
+		if err := setNonFinite(reflect.ValueOf(v), f.path, f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nonFiniteFloat is a NaN or infinite float of a decoded value, at the path of
+// map keys and array indexes leading to it.
+type nonFiniteFloat struct {
+	path  []interface{}
+	value float64
+}
+
+// withoutNonFinite returns value with its non-finite floats replaced by nil,
+// collected in nonFinite.
+func withoutNonFinite(value interface{}, path []interface{}, nonFinite *[]nonFiniteFloat) interface{} {
+	switch value := value.(type) {
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			*nonFinite = append(*nonFinite, nonFiniteFloat{path: path, value: value})
+			return nil
+		}
+	case []interface{}:
+		values := make([]interface{}, len(value))
+		for i, child := range value {
+			values[i] = withoutNonFinite(child, append(path[:len(path):len(path)], i), nonFinite)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			values[key] = withoutNonFinite(child, append(path[:len(path):len(path)], key), nonFinite)
+		}
+		return values
+	}
+	return value
+}
+
+// setNonFinite sets f at path in dst, following the rules json.Unmarshal
+// used to fill dst.
+func setNonFinite(dst reflect.Value, path []interface{}, f float64) error {
+	for dst.Kind() == reflect.Pointer {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+	if len(path) == 0 {
+		switch {
+		case dst.Kind() == reflect.Float32 || dst.Kind() == reflect.Float64:
+			dst.SetFloat(f)
+		case dst.Kind() == reflect.Interface && dst.NumMethod() == 0:
+			dst.Set(reflect.ValueOf(f))
+		default:
+			return fmt.Errorf("cannot decode %v into %s", f, dst.Type())
+		}
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		if dst.IsNil() {
+			return nil
+		}
+		// maps and slices decoded into an interface{} are shared with it
+		return setNonFinite(dst.Elem(), path, f)
+	case reflect.Map:
+		key, ok := path[0].(string)
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		mapKey := reflect.ValueOf(key).Convert(dst.Type().Key())
+		elem := reflect.New(dst.Type().Elem()).Elem()
+		if current := dst.MapIndex(mapKey); current.IsValid() {
+			elem.Set(current)
+		}
+		if err := setNonFinite(elem, path[1:], f); err != nil {
+			return err
+		}
+		dst.SetMapIndex(mapKey, elem)
+	case reflect.Slice, reflect.Array:
+		i, ok := path[0].(int)
+		if !ok || i >= dst.Len() {
+			return nil
+		}
+		return setNonFinite(dst.Index(i), path[1:], f)
+	case reflect.Struct:
+		key, ok := path[0].(string)
+		if !ok {
+			return nil
+		}
+		if field, ok := jsonField(dst, key); ok {
+			return setNonFinite(field, path[1:], f)
+		}
+	}
+	return nil
+}
+
+// jsonField returns the field of the struct dst that json.Unmarshal fills
+// from key: named by its json tag or its Go name, preferring an exact match,
+// and looking into embedded structs.
+func jsonField(dst reflect.Value, key string) (reflect.Value, bool) {
+	var fold reflect.Value
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := dst.Field(i)
+			if embedded.Kind() == reflect.Pointer {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if value, ok := jsonField(embedded, key); ok {
+					return value, true
+				}
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name == key {
+			return dst.Field(i), true
+		}
+		if !fold.IsValid() && strings.EqualFold(name, key) {
+			fold = dst.Field(i)
+		}
+	}
+	return fold, fold.IsValid()
+}
+
+// maxDecodeDepth bounds the nesting of arrays and maps in binary formats.
+const maxDecodeDepth = 512
+
+// byteReader reads the binary formats decoded by this package.
+type byteReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *byteReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *byteReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *byteReader) next(n uint64) ([]byte, error) {
+	if n > uint64(r.remaining()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+func (r *byteReader) uint(size int) (uint64, error) {
+	b, err := r.next(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// mapKey converts a decoded map key to the string key of its JSON form.
+func mapKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	return fmt.Sprint(key)
+}
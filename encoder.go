@@ -0,0 +1,330 @@
+package apiintegration
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BodyEncoder encodes the ObjReq of an APIIntegration into a request body.
+// The returned content type replaces the ContentType of the integration when
+// it is not empty, e.g. to add the boundary of a multipart body.
+type BodyEncoder interface {
+	Encode(v interface{}) ([]byte, string, error)
+}
+
+// BodyEncoderFunc adapts a function to a BodyEncoder.
+type BodyEncoderFunc func(v interface{}) ([]byte, string, error)
+
+func (f BodyEncoderFunc) Encode(v interface{}) ([]byte, string, error) {
+	return f(v)
+}
+
+var (
+	// JSONEncoder encodes with json.Marshal.
+	JSONEncoder BodyEncoder = BodyEncoderFunc(encodeJSON)
+	// XMLEncoder encodes with xml.Marshal.
+	XMLEncoder BodyEncoder = BodyEncoderFunc(encodeXML)
+	// FormEncoder encodes url.Values, maps and structs as an
+	// application/x-www-form-urlencoded body, see MultipartForm for the
+	// struct tags.
+	FormEncoder BodyEncoder = BodyEncoderFunc(encodeForm)
+	// MultipartEncoder encodes a MultipartForm, or the fields accepted by
+	// FormEncoder, as a multipart/form-data body.
+	MultipartEncoder BodyEncoder = BodyEncoderFunc(encodeMultipart)
+)
+
+var (
+	bodyEncodersMu sync.RWMutex
+	bodyEncoders   = map[string]BodyEncoder{
+		"application/json":                  JSONEncoder,
+		"application/xml":                   XMLEncoder,
+		"text/xml":                          XMLEncoder,
+		"application/x-www-form-urlencoded": FormEncoder,
+		"multipart/form-data":               MultipartEncoder,
+	}
+)
+
+// RegisterBodyEncoder sets the BodyEncoder used for the media type of
+// ContentType, e.g. "application/json", replacing any previous one.
+func RegisterBodyEncoder(mediaType string, encoder BodyEncoder) {
+	bodyEncodersMu.Lock()
+	defer bodyEncodersMu.Unlock()
+
+	bodyEncoders[strings.ToLower(mediaType)] = encoder
+}
+
+// bodyEncoderFor returns the BodyEncoder registered for contentType, falling
+// back on the +xml structured syntax suffix. Any other content type, empty or
+// not registered such as text/plain, is encoded as JSON like every ObjReq
+// used to be.
+func bodyEncoderFor(contentType string) BodyEncoder {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return JSONEncoder
+	}
+
+	bodyEncodersMu.RLock()
+	encoder, ok := bodyEncoders[mediaType]
+	bodyEncodersMu.RUnlock()
+	switch {
+	case ok:
+		return encoder
+	case strings.HasSuffix(mediaType, "+xml"):
+		return XMLEncoder
+	}
+	return JSONEncoder
+}
+
+// MultipartForm is an ObjReq sent as a multipart/form-data body.
+//
+// Fields accepts url.Values, map[string]string, map[string][]string,
+// map[string]interface{} or a struct. Struct fields are named by their form
+// tag, then their json tag, then their Go name, "-" skips a field and
+// slices are sent as repeated fields.
+type MultipartForm struct {
+	Fields interface{}
+	Files  []MultipartFile
+}
+
+// MultipartFile is a file part of a MultipartForm.
+type MultipartFile struct {
+	Field       string
+	FileName    string
+	ContentType string
+	Content     io.Reader
+}
+
+func encodeJSON(v interface{}) ([]byte, string, error) {
+	body, err := json.Marshal(v)
+	return body, "", err
+}
+
+func encodeXML(v interface{}) ([]byte, string, error) {
+	body, err := xml.Marshal(v)
+	return body, "", err
+}
+
+func encodeForm(v interface{}) ([]byte, string, error) {
+	values, err := encodeValues(v, "form")
+	if err != nil {
+		return nil, "", err
+	}
+	return []byte(values.Encode()), "", nil
+}
+
+func encodeMultipart(v interface{}) ([]byte, string, error) {
+	form, ok := v.(MultipartForm)
+	if p, isPtr := v.(*MultipartForm); isPtr && p != nil {
+		form, ok = *p, true
+	}
+	if !ok {
+		form = MultipartForm{Fields: v}
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if form.Fields != nil {
+		values, err := encodeValues(form.Fields, "form")
+		if err != nil {
+			return nil, "", err
+		}
+		for key, vals := range values {
+			for _, val := range vals {
+				if err := writer.WriteField(key, val); err != nil {
+					return nil, "", err
+				}
+			}
+		}
+	}
+	for _, file := range form.Files {
+		if err := writeMultipartFile(writer, file); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), writer.FormDataContentType(), nil
+}
+
+func writeMultipartFile(writer *multipart.Writer, file MultipartFile) error {
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(file.Field), escapeQuotes(file.FileName)))
+	header.Set("Content-Type", contentType)
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if file.Content == nil {
+		return nil
+	}
+	_, err = io.Copy(part, file.Content)
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// encodeRaw returns the body of an ObjReq that is already encoded, a []byte
+// or an io.Reader, whatever the ContentType.
+func encodeRaw(v interface{}) ([]byte, bool, error) {
+	switch body := v.(type) {
+	case []byte:
+		return body, true, nil
+	case io.Reader:
+		data, err := ioutil.ReadAll(body)
+		return data, true, err
+	}
+	return nil, false, nil
+}
+
+// encodeValues converts url.Values, string keyed maps and structs to
+// url.Values. Struct fields are named by tag, then by their json tag, then by
+// their Go name.
+func encodeValues(v interface{}, tag string) (url.Values, error) {
+	switch values := v.(type) {
+	case url.Values:
+		return values, nil
+	case map[string][]string:
+		return url.Values(values), nil
+	case map[string]string:
+		result := url.Values{}
+		for key, value := range values {
+			result.Set(key, value)
+		}
+		return result, nil
+	}
+
+	result := url.Values{}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return result, nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot encode %s as values", rv.Type())
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			if err := addValues(result, iter.Key().String(), iter.Value(), false); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			field := rt.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name, omitEmpty := fieldName(field, tag)
+			if name == "-" {
+				continue
+			}
+			if err := addValues(result, name, rv.Field(i), omitEmpty); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cannot encode %s as values", rv.Type())
+	}
+	return result, nil
+}
+
+func fieldName(field reflect.StructField, tag string) (string, bool) {
+	for _, key := range []string{tag, "json"} {
+		value, ok := field.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(value, ",")
+		omitEmpty := false
+		for _, option := range parts[1:] {
+			omitEmpty = omitEmpty || option == "omitempty"
+		}
+		if parts[0] != "" {
+			return parts[0], omitEmpty
+		}
+		return field.Name, omitEmpty
+	}
+	return field.Name, false
+}
+
+func addValues(values url.Values, key string, rv reflect.Value, omitEmpty bool) error {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if omitEmpty && rv.IsZero() {
+		return nil
+	}
+
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < rv.Len(); i++ {
+			if err := addValues(values, key, rv.Index(i), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	value, err := formatValue(rv)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	values.Add(key, value)
+	return nil
+}
+
+func formatValue(rv reflect.Value) (string, error) {
+	switch value := rv.Interface().(type) {
+	case time.Time:
+		return value.Format(time.RFC3339), nil
+	case fmt.Stringer:
+		return value.String(), nil
+	case []byte:
+		return string(value), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, rv.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("cannot encode %s as value", rv.Type())
+}
@@ -0,0 +1,120 @@
+package apiintegration
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+type testPayment struct {
+	XMLName  xml.Name `json:"-" xml:"payment"`
+	Amount   int      `json:"amount" xml:"amount"`
+	Currency string   `json:"currency" form:"ccy" xml:"currency"`
+	Tags     []string `json:"tags,omitempty" xml:"tag"`
+	Note     string   `json:"note,omitempty" xml:"-"`
+	Internal string   `json:"-" xml:"-"`
+}
+
+func TestGenerateBody(t *testing.T) {
+	payment := testPayment{Amount: 100, Currency: "IDR", Tags: []string{"a", "b"}, Internal: "x"}
+	tests := []struct {
+		name            string
+		contentType     string
+		objReq          interface{}
+		wantBody        string
+		wantContentType string
+	}{
+		{"json", "application/json", payment, `{"amount":100,"currency":"IDR","tags":["a","b"]}`, "application/json"},
+		{"json suffix", "application/vnd.api+json", payment, `{"amount":100,"currency":"IDR","tags":["a","b"]}`, "application/vnd.api+json"},
+		{"xml", "application/xml; charset=utf-8", payment, `<payment><amount>100</amount><currency>IDR</currency><tag>a</tag><tag>b</tag></payment>`,
+			"application/xml; charset=utf-8"},
+		{"xml suffix", "application/atom+xml", payment, `<payment><amount>100</amount><currency>IDR</currency><tag>a</tag><tag>b</tag></payment>`,
+			"application/atom+xml"},
+		{"form struct", "application/x-www-form-urlencoded", payment, `amount=100&ccy=IDR&tags=a&tags=b`, "application/x-www-form-urlencoded"},
+		{"form map", "application/x-www-form-urlencoded", map[string]string{"b": "2", "a": "1 2"}, `a=1+2&b=2`, "application/x-www-form-urlencoded"},
+		{"raw bytes", "text/plain", []byte("hello"), "hello", "text/plain"},
+		{"raw reader", "application/json", strings.NewReader(`{"raw":true}`), `{"raw":true}`, "application/json"},
+		{"empty content type", "", payment, `{"amount":100,"currency":"IDR","tags":["a","b"]}`, ""},
+		{"unregistered content type", "text/plain", payment, `{"amount":100,"currency":"IDR","tags":["a","b"]}`, "text/plain"},
+		{"malformed content type", "application/", payment, `{"amount":100,"currency":"IDR","tags":["a","b"]}`, "application/"},
+		{"no body", "application/json", nil, "", "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIIntegration{ContentType: tt.contentType, ObjReq: tt.objReq}
+			body, contentType, err := a.generateBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+			if contentType != tt.wantContentType {
+				t.Errorf("content type = %q, want %q", contentType, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestGenerateBodyMultipart(t *testing.T) {
+	a := &APIIntegration{
+		ContentType: "multipart/form-data",
+		ObjReq: MultipartForm{
+			Fields: map[string]string{"amount": "100"},
+			Files:  []MultipartFile{{Field: "receipt", FileName: `a "b".txt`, Content: strings.NewReader("paid")}},
+		},
+	}
+	body, contentType, err := a.generateBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		t.Fatalf("content type = %q", contentType)
+	}
+
+	form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := form.Value["amount"]; len(got) != 1 || got[0] != "100" {
+		t.Errorf("amount = %v", got)
+	}
+	files := form.File["receipt"]
+	if len(files) != 1 || files[0].Filename != `a "b".txt` || files[0].Header.Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("receipt = %+v", files)
+	}
+	file, err := files[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if content, _ := io.ReadAll(file); string(content) != "paid" {
+		t.Errorf("receipt content = %q", content)
+	}
+}
+
+func TestGenerateBodyEncoder(t *testing.T) {
+	a := &APIIntegration{
+		ContentType: "application/json",
+		ObjReq:      "ignored",
+		BodyEncoder: BodyEncoderFunc(func(v interface{}) ([]byte, string, error) {
+			return []byte("a,b"), "text/csv", nil
+		}),
+	}
+	body, contentType, err := a.generateBody()
+	if err != nil || string(body) != "a,b" || contentType != "text/csv" {
+		t.Errorf("generateBody() = %q, %q, %v", body, contentType, err)
+	}
+}
+
+func TestGenerateBodyFormError(t *testing.T) {
+	a := &APIIntegration{ContentType: "application/x-www-form-urlencoded", ObjReq: map[int]string{1: "a"}}
+	if _, _, err := a.generateBody(); err == nil {
+		t.Error("encoded a map with int keys as a form")
+	}
+}
@@ -0,0 +1,274 @@
+package apiintegration
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// encryptedPrefix starts every value encrypted by an EncryptingRecorder, it is
+// followed by the key ID and the base64 envelope, separated by colons.
+const encryptedPrefix = "enc:v1:"
+
+const dataKeySize = 32
+
+// ErrUnknownKey is returned when decrypting a value whose key ID is not in
+// the keyring.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// ErrNoCurrentKey is returned when encrypting with a keyring that has no
+// current key.
+var ErrNoCurrentKey = errors.New("no current encryption key")
+
+// Keyring holds the AES keys of an EncryptingRecorder by ID. Values are
+// encrypted with the current key and decrypted with the key named in them,
+// so old keys stay in the keyring after a rotation until no row uses them.
+// The zero value is an empty keyring, Rotate sets its current key.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewKeyring returns a keyring whose current key is key, which must be 16, 24
+// or 32 bytes long.
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add adds a key used only for decryption.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil {
+		k.keys = map[string]cipher.AEAD{}
+	}
+	k.keys[id] = aead
+	return nil
+}
+
+// Rotate adds key and makes it the current key.
+func (k *Keyring) Rotate(id string, key []byte) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = id
+	return nil
+}
+
+// Encrypt seals plaintext with a fresh data key, itself sealed with the
+// current key. The column the value is stored in is authenticated so that
+// values cannot be swapped between columns.
+func (k *Keyring) Encrypt(plaintext, column string) (string, error) {
+	k.mu.RLock()
+	id, kek := k.current, k.keys[k.current]
+	k.mu.RUnlock()
+	if kek == nil {
+		return "", ErrNoCurrentKey
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	dek, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	// envelope: sealed data key then sealed plaintext, each after its nonce
+	envelope, err := seal(kek, nil, dataKey, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	envelope, err = seal(dek, envelope, []byte(plaintext), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + id + ":" + base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// Decrypt opens a value returned by Encrypt for the same column. Values that
+// are not encrypted are returned as is.
+func (k *Keyring) Decrypt(value, column string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+
+	k.mu.RLock()
+	kek, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	envelope, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	dataKey, envelope, err := open(kek, envelope, dataKeySize, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	dek, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, _, err := open(dek, envelope, len(envelope)-dek.NonceSize()-dek.Overhead(), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal appends a fresh nonce and the sealed plaintext to dst.
+func seal(aead cipher.AEAD, dst, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, additionalData), nil
+}
+
+// open opens the first nonce and sealed value of size plaintext bytes of
+// envelope, and returns the rest of it.
+func open(aead cipher.AEAD, envelope []byte, size int, additionalData []byte) ([]byte, []byte, error) {
+	n := aead.NonceSize() + size + aead.Overhead()
+	if size < 0 || len(envelope) < n {
+		return nil, nil, fmt.Errorf("malformed encrypted value")
+	}
+	nonce, sealed := envelope[:aead.NonceSize()], envelope[aead.NonceSize():n]
+	plaintext, err := aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, envelope[n:], nil
+}
+
+// EncryptingRecorder encrypts the request and the response of activities
+// before recording them with the recorder it wraps.
+type EncryptingRecorder struct {
+	Recorder ActivityRecorder
+	Keyring  *Keyring
+}
+
+// NewEncryptingRecorder returns an EncryptingRecorder recording one activity
+// at a time, use NewEncryptingBatchRecorder to keep the batches of a
+// BatchRecorder.
+func NewEncryptingRecorder(recorder ActivityRecorder, keyring *Keyring) *EncryptingRecorder {
+	return &EncryptingRecorder{Recorder: recorder, Keyring: keyring}
+}
+
+func (r *EncryptingRecorder) Record(ctx context.Context, activity Activity) error {
+	if err := r.encrypt(&activity); err != nil {
+		return err
+	}
+	return r.Recorder.Record(ctx, activity)
+}
+
+// EncryptingBatchRecorder is an EncryptingRecorder that records batches of
+// activities at once with the BatchRecorder it wraps.
+type EncryptingBatchRecorder struct {
+	*EncryptingRecorder
+	batch BatchRecorder
+}
+
+func NewEncryptingBatchRecorder(recorder BatchRecorder, keyring *Keyring) *EncryptingBatchRecorder {
+	return &EncryptingBatchRecorder{EncryptingRecorder: NewEncryptingRecorder(recorder, keyring), batch: recorder}
+}
+
+// RecordBatch encrypts activities and records them at once.
+func (r *EncryptingBatchRecorder) RecordBatch(ctx context.Context, activities []Activity) error {
+	encrypted := make([]Activity, len(activities))
+	for i, activity := range activities {
+		if err := r.encrypt(&activity); err != nil {
+			return err
+		}
+		encrypted[i] = activity
+	}
+	return r.batch.RecordBatch(ctx, encrypted)
+}
+
+// FindAPIActivityByUserID returns the decrypted activities of userID found by
+// the wrapped recorder.
+func (r *EncryptingRecorder) FindAPIActivityByUserID(ctx context.Context, userID int64) ([]Activity, error) {
+	finder, ok := r.Recorder.(ActivityFinder)
+	if !ok {
+		return nil, fmt.Errorf("%T cannot find api activity", r.Recorder)
+	}
+	return r.decryptAll(finder.FindAPIActivityByUserID(ctx, userID))
+}
+
+// FindAPIActivityByCorrelationID returns the decrypted activities of
+// correlationID found by the wrapped recorder.
+func (r *EncryptingRecorder) FindAPIActivityByCorrelationID(ctx context.Context, correlationID string) ([]Activity, error) {
+	finder, ok := r.Recorder.(ActivityFinder)
+	if !ok {
+		return nil, fmt.Errorf("%T cannot find api activity", r.Recorder)
+	}
+	return r.decryptAll(finder.FindAPIActivityByCorrelationID(ctx, correlationID))
+}
+
+func (r *EncryptingRecorder) decryptAll(activities []Activity, err error) ([]Activity, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range activities {
+		if err := r.decrypt(&activities[i]); err != nil {
+			return nil, err
+		}
+	}
+	return activities, nil
+}
+
+func (r *EncryptingRecorder) encrypt(activity *Activity) error {
+	var err error
+	if activity.Request, err = r.Keyring.Encrypt(activity.Request, "ac_request"); err != nil {
+		return err
+	}
+	activity.Response, err = r.Keyring.Encrypt(activity.Response, "ac_response")
+	return err
+}
+
+func (r *EncryptingRecorder) decrypt(activity *Activity) error {
+	var err error
+	if activity.Request, err = r.Keyring.Decrypt(activity.Request, "ac_request"); err != nil {
+		return err
+	}
+	activity.Response, err = r.Keyring.Decrypt(activity.Response, "ac_response")
+	return err
+}
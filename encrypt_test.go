@@ -0,0 +1,222 @@
+package apiintegration
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testKeyring(t *testing.T) *Keyring {
+	keyring, err := NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring := testKeyring(t)
+	encrypted, err := keyring.Encrypt(`{"card_number":"4111111111111111"}`, "ac_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "enc:v1:k1:") || strings.Contains(encrypted, "4111") {
+		t.Errorf("encrypted = %q", encrypted)
+	}
+	again, err := keyring.Encrypt(`{"card_number":"4111111111111111"}`, "ac_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("the same plaintext encrypted twice gave the same value")
+	}
+	decrypted, err := keyring.Decrypt(encrypted, "ac_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != `{"card_number":"4111111111111111"}` {
+		t.Errorf("decrypted = %q", decrypted)
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	keyring := testKeyring(t)
+	old, err := keyring.Encrypt("old", "ac_response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Rotate("k2", bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	current, err := keyring.Encrypt("current", "ac_response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(current, "enc:v1:k2:") {
+		t.Errorf("encrypted after rotation = %q", current)
+	}
+	for value, want := range map[string]string{old: "old", current: "current"} {
+		got, err := keyring.Decrypt(value, "ac_response")
+		if err != nil || got != want {
+			t.Errorf("Decrypt(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+}
+
+func TestKeyringUnknownKey(t *testing.T) {
+	encrypted, err := testKeyring(t).Encrypt("secret", "ac_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKeyring("k2", bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decrypt(encrypted, "ac_request"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeyringRejectsMovedValue(t *testing.T) {
+	keyring := testKeyring(t)
+	encrypted, err := keyring.Encrypt("secret", "ac_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := keyring.Decrypt(encrypted, "ac_response"); err == nil {
+		t.Errorf("decrypted a value moved to ac_response: %q", got)
+	}
+}
+
+func TestKeyringRejectsMalformed(t *testing.T) {
+	keyring := testKeyring(t)
+	encrypted, err := keyring.Encrypt("secret", "ac_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, "enc:v1:k1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := append([]byte(nil), envelope...)
+	flipped[len(flipped)-1] ^= 1
+
+	tests := map[string]string{
+		"no key id":      "enc:v1:" + base64.StdEncoding.EncodeToString(envelope),
+		"not base64":     "enc:v1:k1:%%%",
+		"empty envelope": "enc:v1:k1:",
+		"truncated":      "enc:v1:k1:" + base64.StdEncoding.EncodeToString(envelope[:len(envelope)-10]),
+		"data key only":  "enc:v1:k1:" + base64.StdEncoding.EncodeToString(envelope[:12+dataKeySize+16]),
+		"tampered":       "enc:v1:k1:" + base64.StdEncoding.EncodeToString(flipped),
+	}
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got, err := keyring.Decrypt(value, "ac_request"); err == nil {
+				t.Errorf("Decrypt(%q) = %q, want an error", value, got)
+			}
+		})
+	}
+}
+
+func TestKeyringPlaintextPassesThrough(t *testing.T) {
+	for _, value := range []string{"", "GET / HTTP/1.1", "enc:v2:k1:abc"} {
+		got, err := testKeyring(t).Decrypt(value, "ac_request")
+		if err != nil || got != value {
+			t.Errorf("Decrypt(%q) = %q, %v", value, got, err)
+		}
+	}
+}
+
+func TestKeyringZeroValue(t *testing.T) {
+	var keyring Keyring
+	if _, err := keyring.Encrypt("secret", "ac_request"); !errors.Is(err, ErrNoCurrentKey) {
+		t.Errorf("error = %v, want ErrNoCurrentKey", err)
+	}
+	if err := keyring.Rotate("k1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := keyring.Encrypt("secret", "ac_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := keyring.Decrypt(encrypted, "ac_request"); err != nil || got != "secret" {
+		t.Errorf("decrypted = %q, %v", got, err)
+	}
+}
+
+func TestEncryptingRecorderFind(t *testing.T) {
+	memory := &MemoryRecorder{}
+	r := NewEncryptingRecorder(memory, testKeyring(t))
+	activity := Activity{UserID: 42, CorrelationID: "req-1", Request: "request body", Response: "response body"}
+	if err := r.Record(context.Background(), activity); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := memory.Activities()[0]
+	if !strings.HasPrefix(stored.Request, encryptedPrefix) || !strings.HasPrefix(stored.Response, encryptedPrefix) {
+		t.Errorf("stored in clear text: %+v", stored)
+	}
+	byUser, err := r.FindAPIActivityByUserID(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byCorrelation, err := r.FindAPIActivityByCorrelationID(context.Background(), "req-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, found := range [][]Activity{byUser, byCorrelation} {
+		if len(found) != 1 || found[0] != activity {
+			t.Errorf("found %+v, want %+v", found, activity)
+		}
+	}
+}
+
+// flakyRecorder fails the Record calls whose number is in fails, counting
+// from 1.
+type flakyRecorder struct {
+	MemoryRecorder
+	mu    sync.Mutex
+	calls int
+	fails map[int]bool
+}
+
+func (r *flakyRecorder) Record(ctx context.Context, activity Activity) error {
+	r.mu.Lock()
+	r.calls++
+	fail := r.fails[r.calls]
+	r.mu.Unlock()
+	if fail {
+		return errors.New("record failed")
+	}
+	return r.MemoryRecorder.Record(ctx, activity)
+}
+
+func TestEncryptingRecorderKeepsRowRetries(t *testing.T) {
+	flaky := &flakyRecorder{fails: map[int]bool{2: true}}
+	r := NewAsyncRecorder(NewEncryptingRecorder(flaky, testKeyring(t)), AsyncRecorderSettings{
+		FlushInterval: time.Hour,
+		Retry:         &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	for i := 1; i <= 3; i++ {
+		if err := r.Record(context.Background(), Activity{Attempt: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed row alone is retried
+	var attempts []int
+	for _, activity := range flaky.Activities() {
+		attempts = append(attempts, activity.Attempt)
+	}
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[1] != 3 || attempts[2] != 2 {
+		t.Errorf("recorded attempts %v, want [1 3 2]", attempts)
+	}
+}
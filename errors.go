@@ -0,0 +1,163 @@
+package apiintegration
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// maxErrorBody is the number of response body bytes kept in an APIError.
+const maxErrorBody = 512
+
+// ErrorKind classifies why an integration call failed. An ErrorKind is itself
+// an error so callers can match a kind with errors.Is:
+//
+//	if errors.Is(err, apiintegration.KindTimeout) { ... }
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindTimeout
+	KindDNS
+	KindConnectionRefused
+	KindTLS
+	KindClientError
+	KindServerError
+	KindDecode
+	KindCircuitOpen
+	KindEncode
+	KindAuth
+)
+
+var errorKindNames = map[ErrorKind]string{
+	KindUnknown:           "unknown",
+	KindTimeout:           "timeout",
+	KindDNS:               "dns failure",
+	KindConnectionRefused: "connection refused",
+	KindTLS:               "tls failure",
+	KindClientError:       "client error",
+	KindServerError:       "server error",
+	KindDecode:            "decode failure",
+	KindCircuitOpen:       "circuit open",
+	KindEncode:            "encode failure",
+	KindAuth:              "auth failure",
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := errorKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+func (k ErrorKind) Error() string {
+	return k.String()
+}
+
+// ErrForbidden matches, with errors.Is, an APIError for a 403 response.
+var ErrForbidden = errors.New("Forbidden")
+
+// APIError is returned by Do for every failed integration call.
+type APIError struct {
+	APICode    string
+	Method     string
+	Host       string
+	StatusCode int
+	Body       string
+	Kind       ErrorKind
+	Err        error
+
+	// Phase is the phase that timed out for a KindTimeout error.
+	Phase TimeoutPhase
+
+	// Envelope is the decoded body of a failed response, set by
+	// CallWithError.
+	Envelope interface{}
+}
+
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("[%s] %s %s - %s", e.APICode, e.Method, e.Host, e.Kind)
+	if e.Phase != PhaseNone {
+		s += fmt.Sprintf(" (%s)", e.Phase)
+	}
+	if e.StatusCode != 0 {
+		s += fmt.Sprintf(" - status %d", e.StatusCode)
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the ErrorKind of e, or ErrForbidden for a 403.
+func (e *APIError) Is(target error) bool {
+	if kind, ok := target.(ErrorKind); ok {
+		return kind == e.Kind
+	}
+	return target == ErrForbidden && e.StatusCode == http.StatusForbidden
+}
+
+func (a *APIIntegration) newError(kind ErrorKind, statusCode int, body []byte, err error) *APIError {
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+	phase := PhaseNone
+	if kind == KindTimeout {
+		phase = timeoutPhase(err)
+	}
+	return &APIError{
+		APICode:    a.APICode,
+		Method:     a.Method,
+		Host:       a.Host,
+		StatusCode: statusCode,
+		Body:       string(body),
+		Kind:       kind,
+		Err:        err,
+		Phase:      phase,
+	}
+}
+
+// classifyError maps a transport error to its ErrorKind.
+func classifyError(err error) ErrorKind {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return KindDNS
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, context.DeadlineExceeded):
+		return KindTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return KindConnectionRefused
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return KindTLS
+	}
+	return KindUnknown
+}
+
+// classifyStatus maps a non-success status code to its ErrorKind.
+func classifyStatus(statusCode int) ErrorKind {
+	switch {
+	case statusCode >= 400 && statusCode < 500:
+		return KindClientError
+	case statusCode >= 500 && statusCode < 600:
+		return KindServerError
+	}
+	return KindUnknown
+}
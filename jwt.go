@@ -0,0 +1,232 @@
+package apiintegration
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultJWTTTL = 5 * time.Minute
+
+// JWTAuth authenticates with a short-lived JWT signed with a service key,
+// sent as "Authorization: Bearer <jwt>". Tokens are cached per audience and
+// subject until ExpiryDelta before they expire, concurrent calls for the same
+// audience and subject minting one token. A JWTAuth must not be copied after
+// first use.
+type JWTAuth struct {
+	// Algorithm is RS256, ES256 or HS256.
+	Algorithm string
+	// Key is a *rsa.PrivateKey for RS256, a P-256 *ecdsa.PrivateKey for
+	// ES256 or a []byte secret for HS256.
+	Key interface{}
+	// KeyID is sent as the kid header, to pick the key when it rotates.
+	KeyID  string
+	Issuer string
+	// Audience defaults to the origin of the request URL, the scheme and
+	// host of the integration Host without its path, such as
+	// "https://api.example.com".
+	Audience string
+	// Subject defaults to the UserID of the integration.
+	Subject string
+	// TTL is the lifetime of a token, default 5 minutes.
+	TTL time.Duration
+	// ExpiryDelta is how long before expiry a token is minted again,
+	// default 10 seconds.
+	ExpiryDelta time.Duration
+	// Claims are added to the registered claims of each token.
+	Claims map[string]interface{}
+	// Now returns the minting time, default time.Now.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	tokens  map[jwtCacheKey]*cachedToken
+	flights map[jwtCacheKey]*tokenFlight
+}
+
+type jwtCacheKey struct {
+	audience string
+	subject  string
+}
+
+func (j *JWTAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	audience := j.Audience
+	if audience == "" {
+		audience = req.URL.Scheme + "://" + req.URL.Host
+	}
+	subject := j.Subject
+	if info, ok := callInfoFrom(ctx); subject == "" && ok && info.UserID != 0 {
+		subject = strconv.FormatInt(info.UserID, 10)
+	}
+
+	token, err := j.token(ctx, jwtCacheKey{audience: audience, subject: subject})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// InvalidateToken drops the cached token sent with req.
+func (j *JWTAuth) InvalidateToken(req *http.Request) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for key, token := range j.tokens {
+		if req.Header.Get("Authorization") == "Bearer "+token.value {
+			delete(j.tokens, key)
+		}
+	}
+}
+
+// token returns the cached token of key, minting a new one outside of the
+// lock when there is none or it is about to expire.
+func (j *JWTAuth) token(ctx context.Context, key jwtCacheKey) (string, error) {
+	j.mu.Lock()
+	if token, ok := j.tokens[key]; ok && token.valid(j.ExpiryDelta) {
+		j.mu.Unlock()
+		return token.value, nil
+	}
+	if flight, ok := j.flights[key]; ok {
+		j.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-flight.done:
+			if flight.err != nil {
+				return "", flight.err
+			}
+			return flight.token.value, nil
+		}
+	}
+	flight := &tokenFlight{done: make(chan struct{})}
+	if j.flights == nil {
+		j.flights = map[jwtCacheKey]*tokenFlight{}
+	}
+	j.flights[key] = flight
+	j.mu.Unlock()
+
+	token, err := j.mint(key)
+
+	j.mu.Lock()
+	if err == nil {
+		j.cache(key, token)
+	}
+	delete(j.flights, key)
+	flight.token, flight.err = token, err
+	j.mu.Unlock()
+	close(flight.done)
+
+	if err != nil {
+		return "", err
+	}
+	return token.value, nil
+}
+
+// cache stores token for key and drops the tokens that would be minted again,
+// so the cache does not grow with every subject ever seen.
+func (j *JWTAuth) cache(key jwtCacheKey, token *cachedToken) {
+	for cached, t := range j.tokens {
+		if !t.valid(j.ExpiryDelta) {
+			delete(j.tokens, cached)
+		}
+	}
+	if j.tokens == nil {
+		j.tokens = map[jwtCacheKey]*cachedToken{}
+	}
+	j.tokens[key] = token
+}
+
+func (j *JWTAuth) mint(key jwtCacheKey) (*cachedToken, error) {
+	now := time.Now
+	if j.Now != nil {
+		now = j.Now
+	}
+	ttl := j.TTL
+	if ttl <= 0 {
+		ttl = defaultJWTTTL
+	}
+	issuedAt := now()
+	expiry := issuedAt.Add(ttl)
+
+	header := map[string]string{"alg": j.Algorithm, "typ": "JWT"}
+	if j.KeyID != "" {
+		header["kid"] = j.KeyID
+	}
+	claims := map[string]interface{}{}
+	for name, value := range j.Claims {
+		claims[name] = value
+	}
+	if j.Issuer != "" {
+		claims["iss"] = j.Issuer
+	}
+	if key.subject != "" {
+		claims["sub"] = key.subject
+	}
+	claims["aud"] = key.audience
+	claims["iat"] = issuedAt.Unix()
+	claims["exp"] = expiry.Unix()
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	signature, err := j.sign([]byte(signingInput))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachedToken{
+		value:  signingInput + "." + base64.RawURLEncoding.EncodeToString(signature),
+		expiry: expiry,
+	}, nil
+}
+
+func (j *JWTAuth) sign(signingInput []byte) ([]byte, error) {
+	digest := sha256.Sum256(signingInput)
+	switch j.Algorithm {
+	case "HS256":
+		secret, ok := j.Key.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("jwt: HS256 needs a []byte key, got %T", j.Key)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	case "RS256":
+		key, ok := j.Key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt: RS256 needs a *rsa.PrivateKey, got %T", j.Key)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case "ES256":
+		key, ok := j.Key.(*ecdsa.PrivateKey)
+		if !ok || key.Curve.Params().BitSize != 256 {
+			return nil, fmt.Errorf("jwt: ES256 needs a P-256 *ecdsa.PrivateKey, got %T", j.Key)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	}
+	return nil, fmt.Errorf("jwt: unsupported algorithm %q", j.Algorithm)
+}
@@ -0,0 +1,239 @@
+package apiintegration
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// authenticateJWT authenticates a request to rawURL for userID and returns
+// the JWT sent.
+func authenticateJWT(t *testing.T, j *JWTAuth, rawURL string, userID int64) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withCallInfo(context.Background(), &APIIntegration{UserID: userID}, 1)
+	if err := j.Authenticate(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		t.Fatalf("Authorization = %q", req.Header.Get("Authorization"))
+	}
+	return token
+}
+
+// splitJWT decodes the header and the claims of token and returns them with
+// its signing input and signature.
+func splitJWT(t *testing.T, token string) (header, claims map[string]interface{}, signingInput string, signature []byte) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %q has %d parts", token, len(parts))
+	}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		decoded, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(decoded, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header, claims, parts[0] + "." + parts[1], signature
+}
+
+func TestJWTAuthAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("shared secret")
+
+	tests := []struct {
+		algorithm string
+		key       interface{}
+		verify    func(digest [32]byte, signingInput string, signature []byte) bool
+	}{
+		{"RS256", rsaKey, func(digest [32]byte, _ string, signature []byte) bool {
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], signature) == nil
+		}},
+		{"ES256", ecKey, func(digest [32]byte, _ string, signature []byte) bool {
+			if len(signature) != 64 {
+				return false
+			}
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+			return ecdsa.Verify(&ecKey.PublicKey, digest[:], r, s)
+		}},
+		{"HS256", secret, func(_ [32]byte, signingInput string, signature []byte) bool {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(signingInput))
+			return hmac.Equal(mac.Sum(nil), signature)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			j := &JWTAuth{
+				Algorithm: tt.algorithm,
+				Key:       tt.key,
+				KeyID:     "key-2024",
+				Issuer:    "billing-service",
+				TTL:       time.Minute,
+				Claims:    map[string]interface{}{"scope": "payments"},
+				Now:       func() time.Time { return now },
+			}
+			header, claims, signingInput, signature := splitJWT(t, authenticateJWT(t, j, "https://api.example.com/v1/payments?id=1", 42))
+
+			if header["alg"] != tt.algorithm || header["typ"] != "JWT" || header["kid"] != "key-2024" {
+				t.Errorf("header = %v", header)
+			}
+			want := map[string]interface{}{
+				"iss":   "billing-service",
+				"sub":   "42",
+				"aud":   "https://api.example.com",
+				"iat":   float64(1700000000),
+				"exp":   float64(1700000060),
+				"scope": "payments",
+			}
+			for name, value := range want {
+				if claims[name] != value {
+					t.Errorf("claim %s = %v, want %v", name, claims[name], value)
+				}
+			}
+			if !tt.verify(sha256.Sum256([]byte(signingInput)), signingInput, signature) {
+				t.Error("signature does not verify")
+			}
+		})
+	}
+}
+
+func TestJWTAuthWrongKey(t *testing.T) {
+	j := &JWTAuth{Algorithm: "RS256", Key: []byte("secret")}
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.com", nil)
+	if err := j.Authenticate(context.Background(), req); err == nil {
+		t.Error("RS256 signed with a []byte key")
+	}
+}
+
+func TestJWTAuthCache(t *testing.T) {
+	var mints atomic.Int32
+	ttl := time.Minute
+	expiresIn := ttl
+	j := &JWTAuth{
+		Algorithm: "HS256",
+		Key:       []byte("secret"),
+		TTL:       ttl,
+		Now: func() time.Time {
+			mints.Add(1)
+			// the token expires expiresIn from now
+			return time.Now().Add(expiresIn - ttl)
+		},
+	}
+
+	first := authenticateJWT(t, j, "https://api.example.com/a", 42)
+	if second := authenticateJWT(t, j, "https://api.example.com/b", 42); second != first || mints.Load() != 1 {
+		t.Errorf("minted %d tokens for one audience and subject, want 1", mints.Load())
+	}
+	authenticateJWT(t, j, "https://api.example.com/a", 43)
+	authenticateJWT(t, j, "https://other.example.com/a", 42)
+	if mints.Load() != 3 {
+		t.Errorf("minted %d tokens for three audiences and subjects, want 3", mints.Load())
+	}
+
+	// tokens within ExpiryDelta of their expiry are minted again
+	expiresIn = 5 * time.Second
+	j.InvalidateToken(&http.Request{Header: http.Header{"Authorization": {"Bearer " + first}}})
+	authenticateJWT(t, j, "https://api.example.com/a", 42)
+	authenticateJWT(t, j, "https://api.example.com/a", 42)
+	if mints.Load() != 5 {
+		t.Errorf("minted %d tokens, want 5", mints.Load())
+	}
+}
+
+func TestJWTAuthPrunesCache(t *testing.T) {
+	j := &JWTAuth{
+		Algorithm:   "HS256",
+		Key:         []byte("secret"),
+		TTL:         time.Minute,
+		ExpiryDelta: time.Minute,
+	}
+	// every token is within ExpiryDelta of its expiry and dropped by the next
+	for userID := int64(1); userID <= 100; userID++ {
+		authenticateJWT(t, j, "https://api.example.com", userID)
+	}
+	if len(j.tokens) != 1 {
+		t.Errorf("cached %d tokens, want 1", len(j.tokens))
+	}
+}
+
+func TestJWTAuthConcurrentMint(t *testing.T) {
+	var mints atomic.Int32
+	release := make(chan struct{})
+	j := &JWTAuth{
+		Algorithm: "HS256",
+		Key:       []byte("secret"),
+		Now: func() time.Time {
+			mints.Add(1)
+			<-release
+			return time.Now()
+		},
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i] = authenticateJWT(t, j, "https://api.example.com", 42)
+		}()
+	}
+	// the cache is not locked while the token is minted
+	for mints.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !j.mu.TryLock() {
+		if time.Now().After(deadline) {
+			t.Fatal("the cache is locked while minting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	j.mu.Unlock()
+	close(release)
+	wg.Wait()
+
+	if mints.Load() != 1 {
+		t.Errorf("minted %d tokens for concurrent callers, want 1", mints.Load())
+	}
+	for _, token := range tokens {
+		if token != tokens[0] {
+			t.Errorf("callers got different tokens")
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package apiintegration
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger receives the structured logs of an APIIntegration as key/value
+// pairs, a *slog.Logger satisfies it.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...any)
+}
+
+// writeLog logs msg with the fields of the call followed by args, whose
+// string values are redacted.
+func (a *APIIntegration) writeLog(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if a.Logger == nil {
+		return
+	}
+
+	fields := []any{
+		"api_code", a.APICode,
+		"method", a.Method,
+		"host", a.redact(a.Host),
+		"user_id", a.UserID,
+	}
+	if id, ok := CorrelationID(ctx); ok {
+		fields = append(fields, "correlation_id", id)
+	}
+	for i := 1; i < len(args); i += 2 {
+		if s, ok := args[i].(string); ok {
+			args[i] = a.redact(s)
+		}
+	}
+	a.Logger.Log(ctx, level, msg, append(fields, args...)...)
+}
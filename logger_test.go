@@ -0,0 +1,103 @@
+package apiintegration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logLines decodes the JSON lines written by a slog.JSONHandler.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestLoggerFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: server.URL + "/orders?access_token=s3cret", UserID: 42,
+		Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	if _, err := a.Do(WithCorrelationID(context.Background(), "req-1"), nil); err == nil {
+		t.Fatal("the call succeeded")
+	}
+
+	lines := logLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines: %s", len(lines), buf.String())
+	}
+	line := lines[0]
+	want := map[string]any{
+		"level":          "ERROR",
+		"msg":            "failed status",
+		"api_code":       "TEST",
+		"method":         http.MethodGet,
+		"host":           server.URL + "/orders?access_token=" + redacted,
+		"user_id":        float64(42),
+		"correlation_id": "req-1",
+		"attempt":        float64(1),
+		"status":         float64(http.StatusInternalServerError),
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("%s = %v, want %v", key, line[key], value)
+		}
+	}
+}
+
+func TestLoggerRedactsArgs(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	host := server.URL + "/orders?api_key=s3cret"
+	server.Close()
+
+	var buf bytes.Buffer
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: host, Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	if _, err := a.Do(context.Background(), nil); err == nil {
+		t.Fatal("the call to a closed server succeeded")
+	}
+	a.writeLog(context.Background(), slog.LevelInfo, "card", "pan", "4111 1111 1111 1111", "count", 1)
+
+	lines := logLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines: %s", len(lines), buf.String())
+	}
+	if msg := lines[0]["error"].(string); strings.Contains(msg, "s3cret") || !strings.Contains(msg, "api_key="+redacted) {
+		t.Errorf("error = %q", msg)
+	}
+	if lines[0]["kind"] != KindConnectionRefused.String() {
+		t.Errorf("kind = %v", lines[0]["kind"])
+	}
+	if pan := lines[1]["pan"]; pan != redacted {
+		t.Errorf("pan = %v", pan)
+	}
+	if count := lines[1]["count"]; count != float64(1) {
+		t.Errorf("count = %v", count)
+	}
+	if _, ok := lines[1]["correlation_id"]; ok {
+		t.Error("correlation_id logged without one in the context")
+	}
+	if strings.Contains(buf.String(), "s3cret") || strings.Contains(buf.String(), "4111") {
+		t.Errorf("secrets were logged: %s", buf.String())
+	}
+}
+
+func TestLoggerNil(t *testing.T) {
+	a := &APIIntegration{APICode: "TEST"}
+	a.writeLog(context.Background(), slog.LevelInfo, "ignored", "key", "value")
+}
@@ -0,0 +1,129 @@
+package apiintegration
+
+import (
+	"net/http"
+)
+
+// RoundTripFunc sends a request and returns its response, it implements
+// http.RoundTripper.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware wraps the round trip of a Client, with access to the request of
+// every attempt, its response and its error. Like any http.RoundTripper, a
+// Middleware must not modify the request it is given but a clone of it, and
+// must close the body of a response it does not return.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// Use adds middlewares to c. They run in the order they are added, inside the
+// ones added before: the first one added sees the request first and the
+// response last.
+//
+// Middlewares run after the Signer of an APIIntegration has signed the
+// request. One setting a signed header, such as AuthMiddleware replacing the
+// Authorization header or HeadersMiddleware setting a header signed by
+// AWSSigV4Signer, invalidates the signature.
+func (c *Client) Use(middlewares ...Middleware) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chain := make([]Middleware, 0, len(c.middlewares)+len(middlewares))
+	chain = append(chain, c.middlewares...)
+	c.middlewares = append(chain, middlewares...)
+}
+
+// chain returns the round trip of c wrapped by its middlewares.
+func (c *Client) chain() RoundTripFunc {
+	c.mu.Lock()
+	middlewares := c.middlewares
+	c.mu.Unlock()
+
+	next := RoundTripFunc(c.roundTrip)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		next = middlewares[i](next)
+	}
+	return next
+}
+
+// HeadersMiddleware sets headers on every request.
+func HeadersMiddleware(headers map[string]string) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			for key, value := range headers {
+				req.Header.Set(key, value)
+			}
+			return next(req)
+		}
+	}
+}
+
+// AuthMiddleware sets the credentials of auth on every request.
+func AuthMiddleware(auth Authenticator) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			if err := auth.Authenticate(req.Context(), req); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
+
+// LocalAuthMiddleware authenticates the requests of APIIntegration calls with
+// IsLocalAPI with LocalTokenAuth and the Token of the call. Other requests,
+// the calls to third party APIs included, are sent as is.
+func LocalAuthMiddleware() Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			info, ok := callInfoFrom(req.Context())
+			if !ok || !info.IsLocalAPI {
+				return next(req)
+			}
+			req = req.Clone(req.Context())
+			if err := (LocalTokenAuth{Token: info.Token}).Authenticate(req.Context(), req); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
+
+// RecordingMiddleware records every request sent through the client as an
+// Activity, with the user, APICode and attempt of the APIIntegration call it
+// belongs to, redacted with DefaultRedactionRules. Requests made outside an
+// APIIntegration are recorded under the host they are sent to. A call given a
+// recorder by Do is recorded by it too, each attempt is then recorded twice:
+// pass Do a nil recorder on a client recording with the middleware.
+func RecordingMiddleware(recorder ActivityRecorder) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+			a := &APIIntegration{APICode: req.URL.Host, Method: req.Method, Host: req.URL.String()}
+			attempt := 1
+			if info, ok := callInfoFrom(ctx); ok {
+				a.UserID, a.Token, a.APICode, attempt = info.UserID, info.Token, info.APICode, info.Attempt
+			}
+
+			clone := redactCredentials(req, credentials{}, a.redaction().replacement())
+			if req.GetBody == nil {
+				// the body can only be read once, by the transport
+				clone.Body = nil
+			}
+			activity := a.newActivity(attempt, clone)
+
+			response, err := next(req)
+			if err != nil {
+				activity.ResponseError = err.Error()
+				a.record(ctx, recorder, activity)
+				return nil, err
+			}
+			response.Body = a.captureResponse(ctx, recorder, activity, response)
+			return response, nil
+		}
+	}
+}
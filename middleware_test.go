@@ -0,0 +1,74 @@
+package apiintegration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalAuthMiddleware(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	client := NewClient(ClientOptions{})
+	client.Use(LocalAuthMiddleware())
+	tests := []struct {
+		name       string
+		isLocalAPI bool
+		want       string
+	}{
+		{"local api", true, "token = local-token"},
+		{"third party api", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIIntegration{
+				APICode:    "TEST",
+				Method:     http.MethodGet,
+				Host:       server.URL,
+				Token:      "local-token",
+				IsLocalAPI: tt.isLocalAPI,
+				Client:     client,
+			}
+			if _, err := a.Do(context.Background(), nil); err != nil {
+				t.Fatal(err)
+			}
+			if authorization != tt.want {
+				t.Errorf("Authorization = %q, want %q", authorization, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientUseOrder(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next RoundTripFunc) RoundTripFunc {
+			return func(req *http.Request) (*http.Response, error) {
+				order = append(order, name+" request")
+				response, err := next(req)
+				order = append(order, name+" response")
+				return response, err
+			}
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := NewClient(ClientOptions{})
+	client.Use(trace("first"))
+	client.Use(trace("second"))
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: server.URL, Client: client}
+	if _, err := a.Do(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first request", "second request", "second response", "first response"}
+	if strings.Join(order, ", ") != strings.Join(want, ", ") {
+		t.Errorf("order = %q, want %q", order, want)
+	}
+}
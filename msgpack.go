@@ -0,0 +1,170 @@
+package apiintegration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// msgpackTimestamp is the extension type of MessagePack timestamps.
+const msgpackTimestamp = -1
+
+func decodeMessagePack(data []byte, v interface{}) error {
+	r := &byteReader{data: data}
+	value, err := decodeMessagePackValue(r, 0)
+	if err != nil {
+		return fmt.Errorf("msgpack: %v", err)
+	}
+	if r.remaining() > 0 {
+		return errors.New("msgpack: trailing data")
+	}
+	return decodeVia(value, v)
+}
+
+func decodeMessagePackValue(r *byteReader, depth int) (interface{}, error) {
+	if depth > maxDecodeDepth {
+		return nil, errors.New("max depth exceeded")
+	}
+	b, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b >= 0x80 && b <= 0x8f:
+		return decodeMessagePackMap(r, uint64(b&0x0f), depth)
+	case b >= 0x90 && b <= 0x9f:
+		return decodeMessagePackArray(r, uint64(b&0x0f), depth)
+	case b >= 0xa0 && b <= 0xbf:
+		s, err := r.next(uint64(b & 0x1f))
+		return string(s), err
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.uint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		bin, err := r.next(n)
+		return append([]byte(nil), bin...), err
+	case 0xc7, 0xc8, 0xc9:
+		n, err := r.uint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMessagePackExt(r, n)
+	case 0xca:
+		n, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := r.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (b - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := r.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeMessagePackExt(r, 1<<(b-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		s, err := r.next(n)
+		return string(s), err
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMessagePackArray(r, n, depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMessagePackMap(r, n, depth)
+	}
+	return nil, fmt.Errorf("unsupported format 0x%02x", b)
+}
+
+func decodeMessagePackArray(r *byteReader, n uint64, depth int) (interface{}, error) {
+	if n > uint64(r.remaining()) {
+		return nil, errors.New("array length exceeds data")
+	}
+	values := make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		value, err := decodeMessagePackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func decodeMessagePackMap(r *byteReader, n uint64, depth int) (interface{}, error) {
+	if n > uint64(r.remaining()) {
+		return nil, errors.New("map length exceeds data")
+	}
+	values := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := decodeMessagePackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeMessagePackValue(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		values[mapKey(key)] = value
+	}
+	return values, nil
+}
+
+func decodeMessagePackExt(r *byteReader, n uint64) (interface{}, error) {
+	extType, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(extType) != msgpackTimestamp {
+		return nil, fmt.Errorf("unsupported extension type %d", int8(extType))
+	}
+
+	ext := &byteReader{data: data}
+	switch n {
+	case 4:
+		sec, _ := ext.uint(4)
+		return time.Unix(int64(sec), 0).UTC(), nil
+	case 8:
+		v, _ := ext.uint(8)
+		return time.Unix(int64(v&0x3ffffffff), int64(v>>34)).UTC(), nil
+	case 12:
+		nsec, _ := ext.uint(4)
+		sec, _ := ext.uint(8)
+		return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+	}
+	return nil, fmt.Errorf("invalid timestamp length %d", n)
+}
@@ -0,0 +1,126 @@
+package apiintegration
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessagePackDecoderVectors(t *testing.T) {
+	tests := []struct {
+		data string
+		want interface{}
+	}{
+		{"00", float64(0)},
+		{"7f", float64(127)},
+		{"ff", float64(-1)},
+		{"e0", float64(-32)},
+		{"cc ff", float64(255)},
+		{"cd 0100", float64(256)},
+		{"ce 00010000", float64(65536)},
+		{"d0 80", float64(-128)},
+		{"d1 8000", float64(-32768)},
+		{"d2 80000000", float64(math.MinInt32)},
+		{"ca 3fc00000", 1.5},
+		{"cb 3ff199999999999a", 1.1},
+		{"c0", nil},
+		{"c2", false},
+		{"c3", true},
+		{"a3 616263", "abc"},
+		{"d9 03 616263", "abc"},
+		{"da 0003 616263", "abc"},
+		{"c4 02 0102", "AQI="},
+		{"93 01 02 03", []interface{}{1.0, 2.0, 3.0}},
+		{"dc 0002 01 02", []interface{}{1.0, 2.0}},
+		{"82 a161 01 a162 92 02 03", map[string]interface{}{"a": 1.0, "b": []interface{}{2.0, 3.0}}},
+		{"81 01 02", map[string]interface{}{"1": 2.0}},
+		{"d6 ff 514b67b0", "2013-03-21T20:04:00Z"},
+		{"c7 0c ff 00000001 00000000514b67b0", "2013-03-21T20:04:00.000000001Z"},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		if err := MessagePackDecoder.Decode(hexData(t, tt.data), &got); err != nil {
+			t.Errorf("%s: %v", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestMessagePackDecoderTypedVectors(t *testing.T) {
+	var max uint64
+	if err := MessagePackDecoder.Decode(hexData(t, "cf ffffffffffffffff"), &max); err != nil || max != math.MaxUint64 {
+		t.Errorf("uint64 = %d, %v", max, err)
+	}
+	var min int64
+	if err := MessagePackDecoder.Decode(hexData(t, "d3 8000000000000000"), &min); err != nil || min != math.MinInt64 {
+		t.Errorf("int64 = %d, %v", min, err)
+	}
+
+	var bin []byte
+	if err := MessagePackDecoder.Decode(hexData(t, "c4 03 010203"), &bin); err != nil || !bytes.Equal(bin, []byte{1, 2, 3}) {
+		t.Errorf("bin = %x, %v", bin, err)
+	}
+
+	// timestamp 64: 500000000ns << 34 | 1363896240s
+	var date time.Time
+	want := time.Date(2013, 3, 21, 20, 4, 0, 500000000, time.UTC)
+	if err := MessagePackDecoder.Decode(hexData(t, "d7 ff 77359400 514b67b0"), &date); err != nil || !date.Equal(want) {
+		t.Errorf("timestamp 64 = %v, %v", date, err)
+	}
+
+	var out struct {
+		A float64 `json:"a"`
+		B []int
+	}
+	if err := MessagePackDecoder.Decode(hexData(t, "82 a161 ca3fc00000 a162 92 02 03"), &out); err != nil || out.A != 1.5 || !reflect.DeepEqual(out.B, []int{2, 3}) {
+		t.Errorf("struct = %+v, %v", out, err)
+	}
+}
+
+func TestMessagePackDecoderNonFinite(t *testing.T) {
+	var nan, inf float64
+	if err := MessagePackDecoder.Decode(hexData(t, "cb 7ff8000000000001"), &nan); err != nil || !math.IsNaN(nan) {
+		t.Errorf("NaN = %v, %v", nan, err)
+	}
+	if err := MessagePackDecoder.Decode(hexData(t, "ca ff800000"), &inf); err != nil || !math.IsInf(inf, -1) {
+		t.Errorf("-Infinity = %v, %v", inf, err)
+	}
+
+	var got []interface{}
+	if err := MessagePackDecoder.Decode(hexData(t, "92 01 cb 7ff0000000000000"), &got); err != nil || got[0] != 1.0 || !math.IsInf(got[1].(float64), 1) {
+		t.Errorf("array = %#v, %v", got, err)
+	}
+}
+
+func TestMessagePackDecoderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"never used format", "c1"},
+		{"truncated array", "92 01"},
+		{"truncated map", "81 01"},
+		{"truncated string", "a5 61"},
+		{"truncated length", "da 00"},
+		{"truncated float", "cb 3ff1"},
+		{"array length exceeds data", "dd ffffffff"},
+		{"map length exceeds data", "df ffffffff"},
+		{"unsupported extension", "d4 01 00"},
+		{"invalid timestamp length", "c7 05 ff 0000000000"},
+		{"trailing data", "00 00"},
+		{"too deep", strings.Repeat("91", maxDecodeDepth+2) + "00"},
+	}
+	for _, tt := range tests {
+		var got interface{}
+		if err := MessagePackDecoder.Decode(hexData(t, tt.data), &got); err == nil {
+			t.Errorf("%s: %s decoded as %#v", tt.name, tt.data, got)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package apiintegration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultTokenExpiryDelta = 10 * time.Second
+
+// TokenInvalidator is implemented by authenticators caching a token the
+// server may reject. When a call gets a 401, Do invalidates the token sent
+// with req and sends the call once more with a fresh one.
+type TokenInvalidator interface {
+	InvalidateToken(req *http.Request)
+}
+
+// OAuth2ClientCredentials authenticates with a token obtained from TokenURL
+// by the OAuth2 client credentials grant. The token is cached until
+// ExpiryDelta before it expires and shared by concurrent calls, only one of
+// them fetching a new token at a time. An OAuth2ClientCredentials must not be
+// copied after first use.
+type OAuth2ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// EndpointParams are added to the token request.
+	EndpointParams url.Values
+	// AuthInParams sends the client credentials in the token request body
+	// instead of with HTTP basic authentication.
+	AuthInParams bool
+	// ExpiryDelta is how long before expiry a token is refreshed, default
+	// 10 seconds.
+	ExpiryDelta time.Duration
+	// Client sends the token requests on its pool but not through its
+	// middlewares, so that an AuthMiddleware of the client does not wait on
+	// its own token. Nil uses the pool shared by the package.
+	Client *Client
+
+	mu     sync.Mutex
+	token  *cachedToken
+	flight *tokenFlight
+}
+
+// cachedToken is a token cached by an authenticator until shortly before
+// its expiry, a zero expiry never expires.
+type cachedToken struct {
+	value  string
+	expiry time.Time
+}
+
+func (t *cachedToken) valid(expiryDelta time.Duration) bool {
+	if t.expiry.IsZero() {
+		return true
+	}
+	if expiryDelta <= 0 {
+		expiryDelta = defaultTokenExpiryDelta
+	}
+	return time.Now().Add(expiryDelta).Before(t.expiry)
+}
+
+// tokenFlight is a token request shared by the callers waiting for it.
+type tokenFlight struct {
+	done  chan struct{}
+	token *cachedToken
+	err   error
+}
+
+type oauth2TokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	TokenType        string      `json:"token_type"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+func (o *OAuth2ClientCredentials) Authenticate(ctx context.Context, req *http.Request) error {
+	token, err := o.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// InvalidateToken drops the cached token when it is the one sent with req.
+func (o *OAuth2ClientCredentials) InvalidateToken(req *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.token != nil && req.Header.Get("Authorization") == "Bearer "+o.token.value {
+		o.token = nil
+	}
+}
+
+// Token returns the cached access token, fetching a new one when there is
+// none or it is about to expire.
+func (o *OAuth2ClientCredentials) Token(ctx context.Context) (string, error) {
+	o.mu.Lock()
+	if o.token != nil && o.token.valid(o.ExpiryDelta) {
+		token := o.token.value
+		o.mu.Unlock()
+		return token, nil
+	}
+	flight := o.flight
+	if flight == nil {
+		flight = &tokenFlight{done: make(chan struct{})}
+		o.flight = flight
+		go o.fetch(context.WithoutCancel(ctx), flight)
+	}
+	o.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-flight.done:
+		if flight.err != nil {
+			return "", flight.err
+		}
+		return flight.token.value, nil
+	}
+}
+
+// fetch requests a token for flight and caches it. It runs detached from the
+// caller so a cancelled caller does not fail the others waiting on flight.
+func (o *OAuth2ClientCredentials) fetch(ctx context.Context, flight *tokenFlight) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	token, err := o.requestToken(ctx)
+
+	o.mu.Lock()
+	if err == nil {
+		o.token = token
+	}
+	o.flight = nil
+	flight.token, flight.err = token, err
+	o.mu.Unlock()
+	close(flight.done)
+}
+
+func (o *OAuth2ClientCredentials) requestToken(ctx context.Context) (*cachedToken, error) {
+	params := url.Values{}
+	for key, values := range o.EndpointParams {
+		params[key] = append([]string(nil), values...)
+	}
+	params.Set("grant_type", "client_credentials")
+	if len(o.Scopes) > 0 {
+		params.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	if o.AuthInParams {
+		params.Set("client_id", o.ClientID)
+		params.Set("client_secret", o.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !o.AuthInParams {
+		req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	}
+
+	client := defaultClient
+	if o.Client != nil {
+		client = o.Client
+	}
+	httpClient := http.Client{Transport: RoundTripFunc(client.roundTrip), Timeout: defaultTimeout}
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: token request: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: read token response: %w", err)
+	}
+	tokenResp, err := parseTokenResponse(response.Header.Get("Content-Type"), body)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: decode token response: %w", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 || tokenResp.AccessToken == "" {
+		msg := tokenResp.Error
+		if tokenResp.ErrorDescription != "" {
+			msg += ": " + tokenResp.ErrorDescription
+		}
+		if msg == "" {
+			msg = "no access token"
+		}
+		return nil, fmt.Errorf("oauth2: token request failed with status %s: %s", response.Status, msg)
+	}
+
+	token := &cachedToken{value: tokenResp.AccessToken}
+	if seconds, err := tokenResp.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		token.expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	return token, nil
+}
+
+// parseTokenResponse decodes a JSON token response, or a form encoded one as
+// sent by some providers.
+func parseTokenResponse(contentType string, body []byte) (*oauth2TokenResponse, error) {
+	tokenResp := &oauth2TokenResponse{}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" || mediaType == "text/plain" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		tokenResp.AccessToken = values.Get("access_token")
+		tokenResp.TokenType = values.Get("token_type")
+		tokenResp.ExpiresIn = json.Number(values.Get("expires_in"))
+		tokenResp.Error = values.Get("error")
+		tokenResp.ErrorDescription = values.Get("error_description")
+		return tokenResp, nil
+	}
+	if len(body) == 0 {
+		return tokenResp, nil
+	}
+	if err := json.Unmarshal(body, tokenResp); err != nil {
+		return nil, err
+	}
+	return tokenResp, nil
+}
+
+// tokenInvalidated reports whether err is a 401 whose token was invalidated.
+func (a *APIIntegration) tokenInvalidated(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	_, ok := a.authenticator().(TokenInvalidator)
+	return ok
+}
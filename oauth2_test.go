@@ -0,0 +1,198 @@
+package apiintegration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenServer issues the tokens tok-1, tok-2... expiring after expiresIn
+// seconds, waiting for release before answering when it is set.
+type tokenServer struct {
+	*httptest.Server
+	fetches   atomic.Int32
+	expiresIn int
+	release   chan struct{}
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	s := &tokenServer{expiresIn: expiresIn}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "client" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+		if s.release != nil {
+			<-s.release
+		}
+		n := s.fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"tok-%d","token_type":"Bearer","expires_in":%d}`, n, s.expiresIn)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) auth() *OAuth2ClientCredentials {
+	return &OAuth2ClientCredentials{TokenURL: s.URL, ClientID: "client", ClientSecret: "secret", Scopes: []string{"read", "write"}}
+}
+
+func TestOAuth2ClientCredentialsCachesToken(t *testing.T) {
+	server := newTokenServer(t, 3600)
+	auth := server.auth()
+
+	for i := 0; i < 3; i++ {
+		token, err := auth.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "tok-1" {
+			t.Fatalf("token = %q, want tok-1", token)
+		}
+	}
+	if n := server.fetches.Load(); n != 1 {
+		t.Fatalf("fetches = %d, want 1", n)
+	}
+}
+
+func TestOAuth2ClientCredentialsSingleFetch(t *testing.T) {
+	server := newTokenServer(t, 3600)
+	server.release = make(chan struct{})
+	auth := server.auth()
+
+	const callers = 50
+	var wg sync.WaitGroup
+	tokens := make([]string, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = auth.Token(context.Background())
+		}(i)
+	}
+	close(server.release)
+	wg.Wait()
+
+	for i := range tokens {
+		if errs[i] != nil || tokens[i] != "tok-1" {
+			t.Fatalf("caller %d got %q, %v", i, tokens[i], errs[i])
+		}
+	}
+	if n := server.fetches.Load(); n != 1 {
+		t.Fatalf("fetches = %d, want 1", n)
+	}
+}
+
+func TestOAuth2ClientCredentialsRefreshesNearExpiry(t *testing.T) {
+	// the token expires within the default expiry delta of 10 seconds
+	server := newTokenServer(t, 10)
+	auth := server.auth()
+
+	for i := 1; i <= 2; i++ {
+		token, err := auth.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("tok-%d", i); token != want {
+			t.Fatalf("token = %q, want %q", token, want)
+		}
+	}
+
+	auth = server.auth()
+	auth.ExpiryDelta = 5 * time.Second
+	first, _ := auth.Token(context.Background())
+	second, _ := auth.Token(context.Background())
+	if first != second {
+		t.Fatalf("token refreshed %q -> %q before ExpiryDelta", first, second)
+	}
+}
+
+func TestOAuth2ClientCredentialsFetchError(t *testing.T) {
+	server := newTokenServer(t, 3600)
+	auth := server.auth()
+	auth.ClientSecret = "wrong"
+
+	if _, err := auth.Token(context.Background()); err == nil {
+		t.Fatal("token fetched with wrong credentials")
+	}
+}
+
+func TestOAuth2ClientCredentialsRefetchOnceAfter401(t *testing.T) {
+	tests := []struct {
+		name       string
+		accepted   string
+		wantErr    bool
+		wantCalls  int32
+		wantTokens int32
+	}{
+		{"fresh token accepted", "tok-2", false, 2, 2},
+		{"fresh token rejected", "", true, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTokenServer(t, 3600)
+			var calls atomic.Int32
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				if tt.accepted == "" || r.Header.Get("Authorization") != "Bearer "+tt.accepted {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}))
+			defer api.Close()
+
+			a := &APIIntegration{APICode: "OAUTH", Method: http.MethodGet, Host: api.URL, Auth: server.auth()}
+			_, err := a.Do(context.Background(), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("api calls = %d, want %d", n, tt.wantCalls)
+			}
+			if n := server.fetches.Load(); n != tt.wantTokens {
+				t.Errorf("token fetches = %d, want %d", n, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestOAuth2ClientCredentialsAsClientMiddleware(t *testing.T) {
+	tokens := newTokenServer(t, 3600)
+	var authorization string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer api.Close()
+
+	// the token requests share the client authenticating with the token
+	client := NewClient(ClientOptions{})
+	auth := tokens.auth()
+	auth.Client = client
+	client.Use(AuthMiddleware(auth))
+
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: api.URL, Client: client}
+	start := time.Now()
+	if _, err := a.Do(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("call took %s", elapsed)
+	}
+	if authorization != "Bearer tok-1" {
+		t.Errorf("Authorization = %q, want Bearer tok-1", authorization)
+	}
+	if requests := client.Stats().Requests; requests != 2 {
+		t.Errorf("client requests = %d, want the token and the call", requests)
+	}
+}
@@ -0,0 +1,506 @@
+package apiintegration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+const (
+	defaultMaxCaptureBytes = 64 << 10
+	defaultRecordTimeout   = 5 * time.Second
+)
+
+const (
+	saveAPIActivityQuery                = "INSERT INTO at_api_activity (ac_user_id, ac_token, ac_api_date, ac_api_name, ac_request, ac_error_request, ac_response, ac_error_response, ac_correlation_id, ac_attempt, ac_created_by, ac_created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, now())"
+	findAPIActivityQuery                = "SELECT id, ac_user_id, ac_token, ac_api_date, ac_api_name, ac_request, ac_error_request, ac_response, ac_error_response, COALESCE(ac_correlation_id, '') AS ac_correlation_id, COALESCE(ac_attempt, 1) AS ac_attempt, ac_created_by, ac_created_at FROM at_api_activity"
+	findAPIActivityByUserIDQuery        = findAPIActivityQuery + " WHERE ac_user_id = $1 ORDER BY ac_created_at DESC"
+	findAPIActivityByCorrelationIDQuery = findAPIActivityQuery + " WHERE ac_correlation_id = $1 ORDER BY ac_created_at"
+)
+
+// Activity is the record of one attempt of an integration call.
+type Activity struct {
+	UserID int64  `json:"user_id"`
+	Token  string `json:"token"`
+	// APIName is the APICode of the call.
+	APIName       string    `json:"api_name"`
+	Attempt       int       `json:"attempt"`
+	Date          time.Time `json:"date"`
+	Request       string    `json:"request"`
+	RequestError  string    `json:"request_error"`
+	Response      string    `json:"response"`
+	ResponseError string    `json:"response_error"`
+	// CorrelationID links the activity to the inbound request that caused
+	// the call.
+	CorrelationID string `json:"correlation_id"`
+}
+
+func (activity Activity) insertArgs() []interface{} {
+	return []interface{}{activity.UserID, activity.Token, activity.Date, activity.APIName, activity.Request, activity.RequestError,
+		activity.Response, activity.ResponseError, activity.CorrelationID, activity.Attempt, activity.UserID}
+}
+
+// ActivityRecorder records the activity of integration calls.
+type ActivityRecorder interface {
+	Record(ctx context.Context, activity Activity) error
+}
+
+// SQLRecorder records activities in the at_api_activity table of Postgres,
+// whose ac_correlation_id and ac_attempt columns are added with:
+//
+//	ALTER TABLE at_api_activity ADD COLUMN ac_correlation_id varchar(64);
+//	CREATE INDEX ON at_api_activity (ac_correlation_id);
+//	ALTER TABLE at_api_activity ADD COLUMN ac_attempt integer NOT NULL DEFAULT 1;
+type SQLRecorder struct {
+	DB *sqlx.DB
+}
+
+func NewSQLRecorder(db *sqlx.DB) *SQLRecorder {
+	return &SQLRecorder{DB: db}
+}
+
+func (r *SQLRecorder) Record(ctx context.Context, activity Activity) error {
+	result, err := r.DB.ExecContext(ctx, saveAPIActivityQuery, activity.insertArgs()...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("failed send apiactivity 0 rows affected")
+	}
+	return nil
+}
+
+// RecordBatch records activities in a single transaction.
+func (r *SQLRecorder) RecordBatch(ctx context.Context, activities []Activity) error {
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PreparexContext(ctx, saveAPIActivityQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, activity := range activities {
+		if _, err := stmt.ExecContext(ctx, activity.insertArgs()...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type activityRow struct {
+	ID            int64     `db:"id"`
+	UserID        int64     `db:"ac_user_id"`
+	Token         string    `db:"ac_token"`
+	Date          time.Time `db:"ac_api_date"`
+	APIName       string    `db:"ac_api_name"`
+	Request       string    `db:"ac_request"`
+	RequestError  string    `db:"ac_error_request"`
+	Response      string    `db:"ac_response"`
+	ResponseError string    `db:"ac_error_response"`
+	CorrelationID string    `db:"ac_correlation_id"`
+	Attempt       int       `db:"ac_attempt"`
+	CreatedBy     int64     `db:"ac_created_by"`
+	CreatedAt     time.Time `db:"ac_created_at"`
+}
+
+func (row activityRow) toActivity() Activity {
+	return Activity{
+		UserID:        row.UserID,
+		Token:         row.Token,
+		APIName:       row.APIName,
+		Attempt:       row.Attempt,
+		Date:          row.Date,
+		Request:       row.Request,
+		RequestError:  row.RequestError,
+		Response:      row.Response,
+		ResponseError: row.ResponseError,
+		CorrelationID: row.CorrelationID,
+	}
+}
+
+// FindAPIActivityByUserID returns the activities of userID, newest first.
+func (r *SQLRecorder) FindAPIActivityByUserID(ctx context.Context, userID int64) ([]Activity, error) {
+	return r.findAPIActivity(ctx, findAPIActivityByUserIDQuery, userID)
+}
+
+// FindAPIActivityByCorrelationID returns the activities of the calls made for
+// the inbound request correlationID, oldest first.
+func (r *SQLRecorder) FindAPIActivityByCorrelationID(ctx context.Context, correlationID string) ([]Activity, error) {
+	return r.findAPIActivity(ctx, findAPIActivityByCorrelationIDQuery, correlationID)
+}
+
+func (r *SQLRecorder) findAPIActivity(ctx context.Context, query string, arg interface{}) ([]Activity, error) {
+	rows := []activityRow{}
+	if err := r.DB.SelectContext(ctx, &rows, query, arg); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("data not found")
+	}
+
+	activities := make([]Activity, 0, len(rows))
+	for _, row := range rows {
+		activities = append(activities, row.toActivity())
+	}
+	return activities, nil
+}
+
+// ActivityFinder is implemented by recorders able to query the activities
+// they recorded.
+type ActivityFinder interface {
+	FindAPIActivityByUserID(ctx context.Context, userID int64) ([]Activity, error)
+	FindAPIActivityByCorrelationID(ctx context.Context, correlationID string) ([]Activity, error)
+}
+
+// MemoryRecorder keeps activities in memory, e.g. for tests.
+type MemoryRecorder struct {
+	mu         sync.Mutex
+	activities []Activity
+}
+
+func (r *MemoryRecorder) Record(ctx context.Context, activity Activity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.activities = append(r.activities, activity)
+	return nil
+}
+
+// Activities returns a copy of the recorded activities, oldest first.
+func (r *MemoryRecorder) Activities() []Activity {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Activity(nil), r.activities...)
+}
+
+// FindAPIActivityByUserID returns the activities of userID, newest first.
+func (r *MemoryRecorder) FindAPIActivityByUserID(ctx context.Context, userID int64) ([]Activity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var activities []Activity
+	for i := len(r.activities) - 1; i >= 0; i-- {
+		if r.activities[i].UserID == userID {
+			activities = append(activities, r.activities[i])
+		}
+	}
+	if len(activities) == 0 {
+		return nil, fmt.Errorf("data not found")
+	}
+	return activities, nil
+}
+
+// FindAPIActivityByCorrelationID returns the activities of correlationID,
+// oldest first.
+func (r *MemoryRecorder) FindAPIActivityByCorrelationID(ctx context.Context, correlationID string) ([]Activity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var activities []Activity
+	for _, activity := range r.activities {
+		if activity.CorrelationID == correlationID {
+			activities = append(activities, activity)
+		}
+	}
+	if len(activities) == 0 {
+		return nil, fmt.Errorf("data not found")
+	}
+	return activities, nil
+}
+
+// Reset drops the recorded activities.
+func (r *MemoryRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.activities = nil
+}
+
+// FileRecorder writes activities as newline delimited JSON.
+type FileRecorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewFileRecorder appends activities to the file at path, creating it and its
+// directory when needed.
+func NewFileRecorder(path string) (*FileRecorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &FileRecorder{w: file, closer: file}, nil
+}
+
+// NewNDJSONRecorder writes activities to w.
+func NewNDJSONRecorder(w io.Writer) *FileRecorder {
+	return &FileRecorder{w: w}
+}
+
+func (r *FileRecorder) Record(ctx context.Context, activity Activity) error {
+	line, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(line)
+	return err
+}
+
+// Close closes the file opened by NewFileRecorder.
+func (r *FileRecorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// MultiRecorder records each activity with every recorder it holds.
+type MultiRecorder []ActivityRecorder
+
+func (m MultiRecorder) Record(ctx context.Context, activity Activity) error {
+	var errs []error
+	for _, recorder := range m {
+		if err := recorder.Record(ctx, activity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NopRecorder records nothing.
+type NopRecorder struct{}
+
+func (NopRecorder) Record(ctx context.Context, activity Activity) error {
+	return nil
+}
+
+// newActivity starts the activity of an attempt with its request, whose
+// credentials are already masked.
+func (a *APIIntegration) newActivity(attempt int, req *http.Request) Activity {
+	activity := a.activity(attempt)
+	rules := a.redaction()
+	rules.redactHeader(req.Header)
+	rules.redactURL(req.URL)
+	head, err := httputil.DumpRequest(req, false)
+	if err != nil {
+		activity.RequestError = err.Error()
+		return activity
+	}
+	body, truncated, err := captureRequestBody(req, a.maxCaptureBytes(), rules)
+	if err != nil {
+		activity.RequestError = err.Error()
+	}
+	activity.Request = formatCapture(head, body, truncated)
+	return activity
+}
+
+// newFailedActivity is the activity of an attempt that failed with err before
+// its request could be built.
+func (a *APIIntegration) newFailedActivity(attempt int, err error) Activity {
+	activity := a.activity(attempt)
+	activity.Request = a.Method + " " + a.Host
+	activity.RequestError = err.Error()
+	return activity
+}
+
+// activity returns the activity of an attempt.
+func (a *APIIntegration) activity(attempt int) Activity {
+	return Activity{
+		UserID:  a.UserID,
+		Token:   a.Token,
+		APIName: a.APICode,
+		Attempt: attempt,
+		Date:    time.Now(),
+	}
+}
+
+func (a *APIIntegration) recordTimeout() time.Duration {
+	if a.RecordTimeout <= 0 {
+		return defaultRecordTimeout
+	}
+	return a.RecordTimeout
+}
+
+func (a *APIIntegration) maxCaptureBytes() int64 {
+	if a.MaxCaptureBytes == 0 {
+		return defaultMaxCaptureBytes
+	}
+	return a.MaxCaptureBytes
+}
+
+// captureRequestBody reads the body of req, which is a clone whose body is
+// not sent, and returns at most max bytes of it once redacted.
+func captureRequestBody(req *http.Request, max int64, rules *RedactionRules) ([]byte, int64, error) {
+	if req.Body == nil || req.Body == http.NoBody || max < 0 {
+		return nil, 0, nil
+	}
+	defer req.Body.Close()
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	body = rules.redactBody(body, req.Header.Get("Content-Type"))
+	if int64(len(body)) > max {
+		return body[:max], int64(len(body)) - max, nil
+	}
+	return body, 0, nil
+}
+
+// captureResponse returns the body of response teeing what the caller reads
+// into activity, the activity is recorded once the body is consumed, fails or
+// is closed.
+func (a *APIIntegration) captureResponse(ctx context.Context, recorder ActivityRecorder, activity Activity, response *http.Response) io.ReadCloser {
+	rules := a.redaction()
+	head := *response
+	head.Body = http.NoBody
+	head.Header = response.Header.Clone()
+	rules.redactHeader(head.Header)
+	return &captureBody{
+		body: response.Body,
+		max:  a.maxCaptureBytes(),
+		done: func(body []byte, truncated int64, err error) {
+			body = rules.redactBody(body, head.Header.Get("Content-Type"))
+			dump, dumpErr := httputil.DumpResponse(&head, false)
+			if err == nil {
+				err = dumpErr
+			}
+			if err != nil {
+				activity.ResponseError = err.Error()
+			}
+			activity.Response = formatCapture(dump, body, truncated)
+			a.record(ctx, recorder, activity)
+		},
+	}
+}
+
+// captureBody tees what is read from body, up to max bytes, and calls done
+// once at the end of body, on a read error or on close. done runs outside of
+// the lock, reads are not held up by a slow recorder.
+type captureBody struct {
+	body io.ReadCloser
+	max  int64
+	done func(body []byte, truncated int64, err error)
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated int64
+	finished  bool
+}
+
+func (c *captureBody) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+
+	c.mu.Lock()
+	c.capture(p[:n])
+	finish := err != nil && c.finish()
+	c.mu.Unlock()
+	if finish {
+		if err == io.EOF {
+			c.done(c.buf.Bytes(), c.truncated, nil)
+		} else {
+			c.done(c.buf.Bytes(), c.truncated, err)
+		}
+	}
+	return n, err
+}
+
+func (c *captureBody) Close() error {
+	err := c.body.Close()
+
+	c.mu.Lock()
+	finish := c.finish()
+	c.mu.Unlock()
+	if finish {
+		c.done(c.buf.Bytes(), c.truncated, nil)
+	}
+	return err
+}
+
+func (c *captureBody) capture(p []byte) {
+	if c.finished || c.max < 0 {
+		return
+	}
+	if room := c.max - int64(c.buf.Len()); int64(len(p)) > room {
+		c.truncated += int64(len(p)) - room
+		p = p[:room]
+	}
+	c.buf.Write(p)
+}
+
+// finish reports whether the capture has just finished, buf and truncated are
+// no longer written once it has.
+func (c *captureBody) finish() bool {
+	if c.finished {
+		return false
+	}
+	c.finished = true
+	return true
+}
+
+// formatCapture appends the captured body to the dumped head of a request or
+// a response, noting how many bytes were left out.
+func formatCapture(head, body []byte, truncated int64) string {
+	capture := string(head) + string(body)
+	if truncated > 0 {
+		capture += fmt.Sprintf("... [%d bytes truncated]", truncated)
+	}
+	return capture
+}
+
+// record redacts the activity and records it with recorder before the call
+// returns, wrap slow recorders with an AsyncRecorder. The record outlives the
+// call, so it does not inherit its cancellation, but it gives up after the
+// RecordTimeout: a full AsyncRecorder with OverflowBlock stalls the call up to
+// that long.
+func (a *APIIntegration) record(ctx context.Context, recorder ActivityRecorder, activity Activity) {
+	if recorder == nil {
+		return
+	}
+	if activity.CorrelationID == "" {
+		activity.CorrelationID, _ = CorrelationID(ctx)
+	}
+	rules := a.redaction()
+	if !rules.KeepToken && activity.Token != "" {
+		activity.Token = rules.replacement()
+	}
+	activity.Request = rules.redactText(activity.Request)
+	activity.RequestError = rules.redactText(activity.RequestError)
+	activity.Response = rules.redactText(activity.Response)
+	activity.ResponseError = rules.redactText(activity.ResponseError)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.recordTimeout())
+	defer cancel()
+	if err := recorder.Record(ctx, activity); err != nil {
+		a.writeLog(ctx, slog.LevelError, "failed record api activity", "attempt", activity.Attempt, "error", err.Error())
+	}
+}
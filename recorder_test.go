@@ -0,0 +1,157 @@
+package apiintegration
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stuckRecorder never takes an activity, like a full AsyncRecorder with
+// OverflowBlock whose writes hang.
+type stuckRecorder struct{}
+
+func (stuckRecorder) Record(ctx context.Context, activity Activity) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRecordTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: server.URL, RecordTimeout: 50 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.SendContext(context.Background(), stuckRecorder{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call stalled on a stuck recorder")
+	}
+}
+
+// blockingRecorder holds each Record until release is closed.
+type blockingRecorder struct {
+	MemoryRecorder
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingRecorder) Record(ctx context.Context, activity Activity) error {
+	r.entered <- struct{}{}
+	<-r.release
+	return r.MemoryRecorder.Record(ctx, activity)
+}
+
+func testResponse(body io.Reader) *http.Response {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       io.NopCloser(body),
+	}
+}
+
+func TestCaptureResponseReadWhileRecording(t *testing.T) {
+	a := &APIIntegration{APICode: "TEST", MaxCaptureBytes: 8}
+	recorder := &blockingRecorder{entered: make(chan struct{}), release: make(chan struct{})}
+	body := bytes.Repeat([]byte("x"), 1<<20)
+	capture := a.captureResponse(context.Background(), recorder, Activity{Attempt: 1}, testResponse(bytes.NewReader(body)))
+
+	p := make([]byte, 16)
+	if _, err := io.ReadFull(capture, p); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error)
+	go func() { closed <- capture.Close() }()
+	<-recorder.entered
+
+	// the rest of the body is read while Close is recording the activity
+	read := make(chan int)
+	go func() {
+		n, _ := io.Copy(io.Discard, capture)
+		read <- int(n)
+	}()
+	select {
+	case n := <-read:
+		if n != len(body)-len(p) {
+			t.Errorf("read %d bytes, want %d", n, len(body)-len(p))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read held up by the recorder")
+	}
+	close(recorder.release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	activities := recorder.Activities()
+	if len(activities) != 1 {
+		t.Fatalf("recorded %d activities, want 1", len(activities))
+	}
+	if want := "\r\n\r\nxxxxxxxx... [8 bytes truncated]"; !strings.HasSuffix(activities[0].Response, want) {
+		t.Errorf("response = %q, want suffix %q", activities[0].Response, want)
+	}
+}
+
+func TestCaptureResponseSizeCap(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxCaptureBytes int64
+		want            string
+	}{
+		{"under the cap", 0, "\r\n\r\n" + strings.Repeat("x", 100)},
+		{"over the cap", 16, "\r\n\r\n" + strings.Repeat("x", 16) + "... [84 bytes truncated]"},
+		{"no body", -1, "\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIIntegration{APICode: "TEST", MaxCaptureBytes: tt.maxCaptureBytes}
+			recorder := &MemoryRecorder{}
+			capture := a.captureResponse(context.Background(), recorder, Activity{Attempt: 1}, testResponse(strings.NewReader(strings.Repeat("x", 100))))
+			if body, err := io.ReadAll(capture); err != nil || len(body) != 100 {
+				t.Fatalf("read %d bytes, %v", len(body), err)
+			}
+			capture.Close()
+
+			activities := recorder.Activities()
+			if len(activities) != 1 {
+				t.Fatalf("recorded %d activities, want 1", len(activities))
+			}
+			if !strings.HasSuffix(activities[0].Response, tt.want) {
+				t.Errorf("response = %q, want suffix %q", activities[0].Response, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptureResponseCloseWithoutRead(t *testing.T) {
+	a := &APIIntegration{APICode: "TEST"}
+	recorder := &MemoryRecorder{}
+	capture := a.captureResponse(context.Background(), recorder, Activity{Attempt: 1}, testResponse(strings.NewReader("unread")))
+	capture.Close()
+	capture.Close()
+
+	activities := recorder.Activities()
+	if len(activities) != 1 {
+		t.Fatalf("recorded %d activities, want 1", len(activities))
+	}
+	if response := activities[0].Response; !strings.HasPrefix(response, "HTTP/1.1 200 OK\r\n") || strings.Contains(response, "unread") {
+		t.Errorf("response = %q", response)
+	}
+}
@@ -0,0 +1,267 @@
+package apiintegration
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// PANPattern matches the card numbers of the major card networks, with
+	// optional space or dash separators.
+	PANPattern = regexp.MustCompile(`\b(?:4\d{3}|5[1-5]\d{2}|2[2-7]\d{2}|3[47]\d{2}|6011)(?:[ -]?\d{4}){2}[ -]?\d{1,7}\b`)
+	// EmailPattern matches email addresses.
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// DefaultRedactionRules masks the usual credentials and card numbers, it is
+// used by an APIIntegration without Redaction.
+var DefaultRedactionRules = &RedactionRules{
+	Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key", "X-Auth-Token", "X-Signature"},
+	QueryKeys: []string{"access_token", "refresh_token", "id_token", "token", "api_key", "apikey", "password",
+		"client_secret", "X-Amz-Signature", "X-Amz-Security-Token"},
+	JSONPaths: []string{"..password", "..client_secret", "..access_token", "..refresh_token", "..id_token", "..api_key",
+		"..secret", "..pin", "..cvv", "..card_number"},
+	Patterns: []*regexp.Regexp{PANPattern},
+}
+
+// RedactionRules masks secrets in the recorded api activity and in logs,
+// before they are persisted or written.
+type RedactionRules struct {
+	// Headers are the request and response headers whose values are masked,
+	// case insensitive.
+	Headers []string
+	// QueryKeys are the keys masked in URLs and in form encoded bodies, case
+	// insensitive.
+	QueryKeys []string
+	// JSONPaths are the fields masked in JSON bodies, dot separated from the
+	// root such as "user.password". A "*" segment matches any key or array
+	// index and a leading ".." matches the path at any depth, such as
+	// "..password". A JSON body that does not parse, such as one truncated
+	// by MaxCaptureBytes, is replaced whole.
+	JSONPaths []string
+	// Patterns are masked wherever they match in the recorded requests,
+	// responses and errors and in logs.
+	Patterns []*regexp.Regexp
+	// Replacement replaces masked values, default "[REDACTED]".
+	Replacement string
+	// KeepToken records the Token of the call in clear text.
+	KeepToken bool
+}
+
+func (a *APIIntegration) redaction() *RedactionRules {
+	if a.Redaction == nil {
+		return DefaultRedactionRules
+	}
+	return a.Redaction
+}
+
+// redact masks the secrets of a log line.
+func (a *APIIntegration) redact(s string) string {
+	return a.redaction().redactText(s)
+}
+
+func (r *RedactionRules) replacement() string {
+	if r.Replacement == "" {
+		return redacted
+	}
+	return r.Replacement
+}
+
+func (r *RedactionRules) redactHeader(header http.Header) {
+	for _, name := range r.Headers {
+		if _, ok := header[http.CanonicalHeaderKey(name)]; ok {
+			header.Set(name, r.replacement())
+		}
+	}
+}
+
+func (r *RedactionRules) redactURL(u *url.URL) {
+	if query, ok := r.redactValues(u.RawQuery); ok {
+		u.RawQuery = query
+	}
+}
+
+// redactValues masks the query keys of an encoded query or form, and reports
+// whether any was found.
+func (r *RedactionRules) redactValues(encoded string) (string, bool) {
+	if encoded == "" || len(r.QueryKeys) == 0 {
+		return encoded, false
+	}
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return encoded, false
+	}
+	found := false
+	for key := range values {
+		if r.isQueryKey(key) {
+			values.Set(key, r.replacement())
+			found = true
+		}
+	}
+	if !found {
+		return encoded, false
+	}
+	return values.Encode(), true
+}
+
+func (r *RedactionRules) isQueryKey(key string) bool {
+	for _, queryKey := range r.QueryKeys {
+		if strings.EqualFold(key, queryKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactBody masks the JSON paths of a JSON body or the query keys of a form
+// encoded body. Other bodies are returned as is.
+func (r *RedactionRules) redactBody(body []byte, contentType string) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if form, ok := r.redactValues(string(body)); ok {
+			return []byte(form)
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return r.redactJSON(body)
+	}
+	return body
+}
+
+func (r *RedactionRules) redactJSON(body []byte) []byte {
+	if len(r.JSONPaths) == 0 {
+		return body
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		// the paths can't be found in a body that doesn't parse
+		if len(bytes.TrimSpace(body)) == 0 {
+			return body
+		}
+		return []byte(r.replacement())
+	}
+
+	paths := make([][]string, 0, len(r.JSONPaths))
+	for _, path := range r.JSONPaths {
+		paths = append(paths, strings.Split(path, "."))
+	}
+	if !r.redactJSONValue(v, nil, paths) {
+		return body
+	}
+	redactedBody, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return redactedBody
+}
+
+// redactJSONValue masks the children of v matching paths, trail is the path
+// of v, and reports whether any was masked.
+func (r *RedactionRules) redactJSONValue(v interface{}, trail []string, paths [][]string) bool {
+	found := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childTrail := append(trail[:len(trail):len(trail)], key)
+			if matchJSONPath(childTrail, paths) {
+				v[key] = r.replacement()
+				found = true
+				continue
+			}
+			found = r.redactJSONValue(child, childTrail, paths) || found
+		}
+	case []interface{}:
+		for i, child := range v {
+			childTrail := append(trail[:len(trail):len(trail)], strconv.Itoa(i))
+			if matchJSONPath(childTrail, paths) {
+				v[i] = r.replacement()
+				found = true
+				continue
+			}
+			found = r.redactJSONValue(child, childTrail, paths) || found
+		}
+	}
+	return found
+}
+
+func matchJSONPath(trail []string, paths [][]string) bool {
+	for _, path := range paths {
+		// "..a.b" splits into "", "", "a", "b" and matches at any depth
+		anyDepth := len(path) > 2 && path[0] == "" && path[1] == ""
+		if anyDepth {
+			path = path[2:]
+			if len(trail) < len(path) {
+				continue
+			}
+		} else if len(trail) != len(path) {
+			continue
+		}
+
+		matched := true
+		offset := len(trail) - len(path)
+		for i, segment := range path {
+			if segment != "*" && !strings.EqualFold(segment, trail[offset+i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// redactText masks the patterns and the query keys of URLs found in s.
+func (r *RedactionRules) redactText(s string) string {
+	s = r.redactQueryText(s)
+	for _, pattern := range r.Patterns {
+		s = pattern.ReplaceAllString(s, r.replacement())
+	}
+	return s
+}
+
+// redactQueryText masks the values of the query keys following a ? or a & in
+// s, such as in the URL of an error.
+func (r *RedactionRules) redactQueryText(s string) string {
+	if len(r.QueryKeys) == 0 {
+		return s
+	}
+	lower := strings.ToLower(s)
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '?' && s[i] != '&' {
+			continue
+		}
+		for _, key := range r.QueryKeys {
+			prefix := strings.ToLower(key) + "="
+			if !strings.HasPrefix(lower[i+1:], prefix) {
+				continue
+			}
+			start := i + 1 + len(prefix)
+			end := start
+			for end < len(s) && !strings.ContainsRune("& \t\r\n\"'#", rune(s[end])) {
+				end++
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(r.replacement())
+			last = end
+			i = end - 1
+			break
+		}
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package apiintegration
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"nested", `{"user":{"name":"alice","password":"hunter2"}}`, `{"user":{"name":"alice","password":"[REDACTED]"}}`},
+		{"array", `[{"card_number":"4111111111111111"}]`, `[{"card_number":"[REDACTED]"}]`},
+		{"no secret", `{"name":"alice"}`, `{"name":"alice"}`},
+		{"truncated", `{"name":"alice","password":"hun`, `[REDACTED]`},
+		{"not json", `password=hunter2`, `[REDACTED]`},
+		{"empty", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(DefaultRedactionRules.redactJSON([]byte(tt.body))); got != tt.want {
+				t.Errorf("redactJSON(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptureResponseRedactsTruncatedJSON(t *testing.T) {
+	a := &APIIntegration{APICode: "TEST", MaxCaptureBytes: 100}
+	recorder := &MemoryRecorder{}
+	body := `{"user":"alice","password":"hunter2","history":"` + strings.Repeat("x", 200) + `"}`
+	response := testResponse(strings.NewReader(body))
+	response.Header.Set("Content-Type", "application/json; charset=utf-8")
+	capture := a.captureResponse(context.Background(), recorder, Activity{Attempt: 1}, response)
+	io.Copy(io.Discard, capture)
+	capture.Close()
+
+	activities := recorder.Activities()
+	if len(activities) != 1 {
+		t.Fatalf("recorded %d activities, want 1", len(activities))
+	}
+	if response := activities[0].Response; strings.Contains(response, "hunter2") || !strings.Contains(response, "[REDACTED]... [") {
+		t.Errorf("response = %q", response)
+	}
+}
@@ -0,0 +1,44 @@
+package apiintegration
+
+import (
+	"net/http"
+	"time"
+)
+
+// Response is the outcome of one integration call made by APIIntegration.Do.
+type Response struct {
+	APICode    string
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+	URL        string
+	Duration   time.Duration
+	Attempts   int
+}
+
+// isSuccess reports whether statusCode is one of the success statuses of a.
+func (a *APIIntegration) isSuccess(statusCode int) bool {
+	if len(a.SuccessStatuses) == 0 {
+		return statusCode >= 200 && statusCode < 300
+	}
+	for _, status := range a.SuccessStatuses {
+		if status == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+func newResponse(apiCode string, response *http.Response, body []byte, start time.Time, attempts int) *Response {
+	return &Response{
+		APICode:    apiCode,
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Header:     response.Header,
+		Body:       body,
+		URL:        response.Request.URL.String(),
+		Duration:   time.Since(start),
+		Attempts:   attempts,
+	}
+}
@@ -0,0 +1,193 @@
+package apiintegration
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = 100 * time.Millisecond
+	defaultRetryMaxDelay    = 5 * time.Second
+)
+
+var (
+	defaultRetryableStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	defaultRetryableKinds    = []ErrorKind{KindTimeout, KindConnectionRefused}
+)
+
+// RetryPolicy configures how Do re-sends a failed call. Zero fields fall back
+// to the defaults: 3 attempts, 100ms base delay, 5s max delay, retry on
+// 429/502/503/504 and on timeout and connection refused errors.
+//
+// Only calls with an idempotent method, or with an Idempotency-Key header, are
+// retried on any of those failures. Other calls, such as a POST the server
+// may have processed before failing, are only retried when the request was
+// never sent: connection refused, DNS failure or dial timeout.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// BaseDelay is the delay before the second attempt, doubled on each
+	// following attempt up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction, between 0 and 1, of each delay that is
+	// randomly subtracted from it. Values out of range are clamped.
+	Jitter            float64
+	RetryableStatuses []int
+	RetryableKinds    []ErrorKind
+	// HonorRetryAfter waits for the Retry-After header of the response when
+	// it is present, capped by MaxDelay.
+	HonorRetryAfter bool
+	// RetryNonIdempotent retries the calls of every method alike.
+	RetryNonIdempotent bool
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil {
+		return 1
+	}
+	if p.MaxAttempts <= 0 {
+		return defaultRetryMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay <= 0 {
+		return defaultRetryMaxDelay
+	}
+	return p.MaxDelay
+}
+
+// retryable reports whether the call failed with err should be sent again.
+func (p *RetryPolicy) retryable(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	statuses := p.RetryableStatuses
+	if statuses == nil {
+		statuses = defaultRetryableStatuses
+	}
+	for _, status := range statuses {
+		if apiErr.StatusCode == status {
+			return true
+		}
+	}
+
+	kinds := p.RetryableKinds
+	if kinds == nil {
+		kinds = defaultRetryableKinds
+	}
+	for _, kind := range kinds {
+		if apiErr.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait after the given failed attempt.
+func (p *RetryPolicy) delay(attempt int, resp *Response) time.Duration {
+	maxDelay := p.maxDelay()
+	if p.HonorRetryAfter && resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if d > maxDelay {
+				return maxDelay
+			}
+			return d
+		}
+	}
+
+	d := p.BaseDelay
+	if d <= 0 {
+		d = defaultRetryBaseDelay
+	}
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	if jitter := math.Min(p.Jitter, 1); jitter > 0 {
+		d -= time.Duration(rand.Float64() * jitter * float64(d))
+	}
+	return d
+}
+
+// retryable reports whether the call of a failed with err should be sent
+// again.
+func (a *APIIntegration) retryable(err error) bool {
+	if !a.Retry.retryable(err) {
+		return false
+	}
+	return a.Retry.RetryNonIdempotent || a.idempotent() || notSent(err)
+}
+
+// idempotent reports whether sending the call of a twice has the effect of
+// sending it once.
+func (a *APIIntegration) idempotent() bool {
+	switch strings.ToUpper(a.Method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	for key := range a.Headers {
+		if strings.EqualFold(key, "Idempotency-Key") {
+			return true
+		}
+	}
+	return false
+}
+
+// notSent reports whether the call failed with err before its request
+// reached the server.
+func notSent(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Kind {
+	case KindConnectionRefused, KindDNS:
+		return true
+	case KindTimeout:
+		return apiErr.Phase == PhaseDial
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		d := time.Until(date)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,146 @@
+package apiintegration
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingServer answers status to the first failures requests and 200 OK to
+// the others.
+func failingServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestDoRetry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		name        string
+		method      string
+		headers     map[string]string
+		refused     bool
+		maxAttempts int
+		wantErr     bool
+		wantHits    int32
+		wantRecords int
+	}{
+		{name: "get retried on 503", method: http.MethodGet, maxAttempts: 3, wantHits: 3, wantRecords: 3},
+		{name: "get gives up after max attempts", method: http.MethodGet, maxAttempts: 2, wantErr: true, wantHits: 2, wantRecords: 2},
+		{name: "post not retried on 503", method: http.MethodPost, maxAttempts: 3, wantErr: true, wantHits: 1, wantRecords: 1},
+		{name: "post with idempotency key retried on 503", method: http.MethodPost, headers: map[string]string{"Idempotency-Key": "k-1"},
+			maxAttempts: 3, wantHits: 3, wantRecords: 3},
+		{name: "post retried on connection refused", method: http.MethodPost, refused: true, maxAttempts: 3, wantErr: true, wantRecords: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, hits := failingServer(t, 2, http.StatusServiceUnavailable)
+			host := server.URL
+			if tt.refused {
+				host = refused
+			}
+			a := &APIIntegration{
+				APICode: "TEST",
+				Method:  tt.method,
+				Host:    host,
+				ObjReq:  map[string]int{"amount": 100},
+				Headers: tt.headers,
+				Retry:   &RetryPolicy{MaxAttempts: tt.maxAttempts, BaseDelay: time.Millisecond},
+			}
+			recorder := &MemoryRecorder{}
+			resp, err := a.Do(context.Background(), recorder)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && resp.Attempts != tt.maxAttempts {
+				t.Errorf("attempts = %d, want %d", resp.Attempts, tt.maxAttempts)
+			}
+			if hits.Load() != tt.wantHits {
+				t.Errorf("server hit %d times, want %d", hits.Load(), tt.wantHits)
+			}
+			activities := recorder.Activities()
+			if len(activities) != tt.wantRecords {
+				t.Fatalf("recorded %d activities, want %d", len(activities), tt.wantRecords)
+			}
+			for i, activity := range activities {
+				if activity.Attempt != i+1 || activity.APIName != "TEST" {
+					t.Errorf("activity %d is attempt %d of %q", i, activity.Attempt, activity.APIName)
+				}
+			}
+		})
+	}
+}
+
+func TestDoRetryCancelledDuringBackoff(t *testing.T) {
+	server, hits := failingServer(t, 1, http.StatusServiceUnavailable)
+	a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: server.URL, Retry: &RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := a.Do(ctx, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Do returned after %s", elapsed)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("server hit %d times, want 1", hits.Load())
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	retryAfter := func(value string) *Response {
+		return &Response{Header: http.Header{"Retry-After": {value}}}
+	}
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempt  int
+		resp     *Response
+		min, max time.Duration
+	}{
+		{"backoff", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 3, nil, 400 * time.Millisecond, 400 * time.Millisecond},
+		{"backoff capped", RetryPolicy{BaseDelay: time.Second, MaxDelay: 3 * time.Second}, 5, nil, 3 * time.Second, 3 * time.Second},
+		{"retry after seconds", RetryPolicy{HonorRetryAfter: true}, 1, retryAfter("2"), 2 * time.Second, 2 * time.Second},
+		{"retry after date", RetryPolicy{HonorRetryAfter: true}, 1, retryAfter(time.Now().Add(3 * time.Second).UTC().Format(http.TimeFormat)),
+			time.Second, 3 * time.Second},
+		{"retry after past date", RetryPolicy{HonorRetryAfter: true}, 1, retryAfter("Mon, 02 Jan 2006 15:04:05 GMT"), 0, 0},
+		{"retry after capped", RetryPolicy{HonorRetryAfter: true, MaxDelay: time.Second}, 1, retryAfter("120"), time.Second, time.Second},
+		{"retry after ignored", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 1, retryAfter("120"), 100 * time.Millisecond, 100 * time.Millisecond},
+		{"retry after malformed", RetryPolicy{HonorRetryAfter: true, BaseDelay: 100 * time.Millisecond}, 1, retryAfter("soon"),
+			100 * time.Millisecond, 100 * time.Millisecond},
+		{"jitter", RetryPolicy{BaseDelay: time.Second, Jitter: 0.5}, 1, nil, 500 * time.Millisecond, time.Second},
+		{"jitter above one", RetryPolicy{BaseDelay: time.Second, Jitter: 3}, 1, nil, 0, time.Second},
+		{"negative jitter", RetryPolicy{BaseDelay: time.Second, Jitter: -1}, 1, nil, time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if d := tt.policy.delay(tt.attempt, tt.resp); d < tt.min || d > tt.max {
+					t.Fatalf("delay = %s, want between %s and %s", d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
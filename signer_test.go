@@ -0,0 +1,74 @@
+package apiintegration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHMACSignerVector checks the test vector documented on HMACSigner.
+func TestHMACSignerVector(t *testing.T) {
+	body := []byte(`{"amount":100}`)
+	req, err := http.NewRequest(http.MethodPost, "https://api.example.com/v1/payments?id=1", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stringToSign string
+	signer := &HMACSigner{
+		KeyID:  "key-1",
+		Secret: []byte("secret"),
+		Now:    func() time.Time { return time.Unix(1700000000, 0) },
+		Canonicalize: func(r CanonicalRequest) string {
+			stringToSign = DefaultCanonicalRequest(r)
+			return stringToSign
+		},
+	}
+	if err := signer.Sign(req, body); err != nil {
+		t.Fatal(err)
+	}
+
+	wantStringToSign := "POST\n/v1/payments?id=1\n1700000000\n4d4bbe59c6aad22442cde199a6a8a5f034405fcd78fb5a81c24ef249de1c45f1"
+	if stringToSign != wantStringToSign {
+		t.Errorf("string to sign = %q, want %q", stringToSign, wantStringToSign)
+	}
+	headers := map[string]string{
+		"X-Signature": "533460b43287418afc5f79c8ddfa95569e0ce1b3a2a38f748eb4e2115d871ef8",
+		"X-Timestamp": "1700000000",
+		"X-Key-Id":    "key-1",
+	}
+	for name, want := range headers {
+		if got := req.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestAWSSigV4SignerGetVanilla checks the get-vanilla case of the AWS SigV4
+// test suite documented on AWSSigV4Signer.
+func TestAWSSigV4SignerGetVanilla(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &AWSSigV4Signer{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		Now:             func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	if err := signer.Sign(req, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+}
@@ -0,0 +1,265 @@
+package apiintegration
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout             = 5 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// Timeouts bounds each phase of a call. A zero field falls back to the
+// default noted on it, or leaves its phase bounded by Overall only.
+type Timeouts struct {
+	// Dial bounds the DNS lookup and TCP connect of a new connection,
+	// default 30 seconds.
+	Dial time.Duration
+	// TLSHandshake bounds the TLS handshake of a new connection, default 10
+	// seconds.
+	TLSHandshake time.Duration
+	// ResponseHeader bounds the wait from the request being written to the
+	// first byte of the response.
+	ResponseHeader time.Duration
+	// BodyIdle bounds the wait for each read of the response body.
+	BodyIdle time.Duration
+	// Overall bounds the whole call, reading the body included. It defaults
+	// to Timeout seconds, or 5 seconds.
+	Overall time.Duration
+}
+
+// withDefaults returns t with the default Dial and TLSHandshake timeouts,
+// which the transport of a Client leaves to the phase timers so that they
+// can be raised per call and are reported with their phase.
+func (t Timeouts) withDefaults() Timeouts {
+	if t.Dial <= 0 {
+		t.Dial = defaultDialTimeout
+	}
+	if t.TLSHandshake <= 0 {
+		t.TLSHandshake = defaultTLSHandshakeTimeout
+	}
+	return t
+}
+
+// TimeoutPhase is the phase of a call that timed out.
+type TimeoutPhase int
+
+const (
+	PhaseNone TimeoutPhase = iota
+	PhaseOverall
+	PhaseDial
+	PhaseTLSHandshake
+	PhaseResponseHeader
+	PhaseBodyRead
+)
+
+func (p TimeoutPhase) String() string {
+	switch p {
+	case PhaseNone:
+		return "none"
+	case PhaseOverall:
+		return "overall"
+	case PhaseDial:
+		return "dial"
+	case PhaseTLSHandshake:
+		return "tls handshake"
+	case PhaseResponseHeader:
+		return "response header"
+	case PhaseBodyRead:
+		return "body read"
+	}
+	return fmt.Sprintf("TimeoutPhase(%d)", int(p))
+}
+
+// TimeoutError is the transport error of a call whose phase timed out.
+type TimeoutError struct {
+	Phase TimeoutPhase
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout after %s", e.Phase, e.After)
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// timeoutPhase returns the phase of a timeout error, PhaseOverall when it
+// did not come from a phase timeout.
+func timeoutPhase(err error) TimeoutPhase {
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return timeoutErr.Phase
+	}
+	return PhaseOverall
+}
+
+// phaseTransport enforces the phase timeouts of each request it sends.
+type phaseTransport struct {
+	next     http.RoundTripper
+	timeouts Timeouts
+}
+
+func (t *phaseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timers := &phaseTimers{cancel: cancel}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			timers.start(PhaseDial, t.timeouts.Dial)
+		},
+		ConnectStart: func(string, string) {
+			timers.start(PhaseDial, t.timeouts.Dial)
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				timers.stop(PhaseDial)
+			}
+		},
+		TLSHandshakeStart: func() {
+			timers.start(PhaseTLSHandshake, t.timeouts.TLSHandshake)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			timers.stop(PhaseTLSHandshake)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			timers.start(PhaseResponseHeader, t.timeouts.ResponseHeader)
+		},
+		GotFirstResponseByte: func() {
+			timers.stop(PhaseResponseHeader)
+		},
+	}
+
+	response, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	timers.stopAll()
+	if err != nil {
+		if cause := context.Cause(ctx); errors.As(cause, new(*TimeoutError)) {
+			err = cause
+		}
+		cancel(nil)
+		return nil, err
+	}
+
+	response.Body = &idleTimeoutBody{
+		ReadCloser: response.Body,
+		ctx:        ctx,
+		cancel:     cancel,
+		timers:     timers,
+		idle:       t.timeouts.BodyIdle,
+	}
+	return response, nil
+}
+
+// phaseTimers cancels the request with a TimeoutError when a phase runs
+// longer than its timeout.
+type phaseTimers struct {
+	cancel context.CancelCauseFunc
+
+	mu     sync.Mutex
+	timers map[TimeoutPhase]*time.Timer
+}
+
+// start starts the timer of phase unless it is already running.
+func (p *phaseTimers) start(phase TimeoutPhase, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.timers == nil {
+		p.timers = map[TimeoutPhase]*time.Timer{}
+	}
+	if _, ok := p.timers[phase]; ok {
+		return
+	}
+	p.timers[phase] = time.AfterFunc(d, func() {
+		p.cancel(&TimeoutError{Phase: phase, After: d})
+	})
+}
+
+// reset restarts the timer of phase.
+func (p *phaseTimers) reset(phase TimeoutPhase, d time.Duration) {
+	p.stop(phase)
+	p.start(phase, d)
+}
+
+func (p *phaseTimers) stop(phase TimeoutPhase) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if timer, ok := p.timers[phase]; ok {
+		timer.Stop()
+		delete(p.timers, phase)
+	}
+}
+
+func (p *phaseTimers) stopAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for phase, timer := range p.timers {
+		timer.Stop()
+		delete(p.timers, phase)
+	}
+}
+
+// idleTimeoutBody fails a read of the response body that waits longer than
+// idle, and releases the request context once closed.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	timers *phaseTimers
+	idle   time.Duration
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timers.reset(PhaseBodyRead, b.idle)
+	n, err := b.ReadCloser.Read(p)
+	b.timers.stop(PhaseBodyRead)
+	if err != nil && err != io.EOF {
+		if cause := context.Cause(b.ctx); errors.As(cause, new(*TimeoutError)) {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timers.stopAll()
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
+// validateTimeout returns the overall timeout of a call.
+func (a *APIIntegration) validateTimeout() time.Duration {
+	if a.Timeouts.Overall > 0 {
+		return a.Timeouts.Overall
+	}
+	if a.Timeout == 0 {
+		return defaultTimeout
+	}
+	return time.Duration(a.Timeout) * time.Second
+}
+
+// httpClient returns the http.Client sending one attempt of a call.
+func (a *APIIntegration) httpClient() http.Client {
+	client := a.client().httpClient(a.validateTimeout())
+	client.Transport = &phaseTransport{next: client.Transport, timeouts: a.Timeouts.withDefaults()}
+	return client
+}
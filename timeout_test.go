@@ -0,0 +1,111 @@
+package apiintegration
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stall waits for d or until the client gives up on r.
+func stall(r *http.Request, d time.Duration) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(d):
+	}
+}
+
+func TestDoPhaseTimeouts(t *testing.T) {
+	slowHeader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stall(r, 5*time.Second)
+	}))
+	defer slowHeader.Close()
+	slowBody := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[`))
+		w.(http.Flusher).Flush()
+		stall(r, 5*time.Second)
+	}))
+	defer slowBody.Close()
+
+	// accepts connections and never answers the TLS handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	tests := []struct {
+		name     string
+		host     string
+		timeouts Timeouts
+		phase    TimeoutPhase
+	}{
+		{"response header", slowHeader.URL, Timeouts{ResponseHeader: 100 * time.Millisecond}, PhaseResponseHeader},
+		{"body read", slowBody.URL, Timeouts{BodyIdle: 100 * time.Millisecond}, PhaseBodyRead},
+		{"tls handshake", "https://" + listener.Addr().String(), Timeouts{TLSHandshake: 100 * time.Millisecond}, PhaseTLSHandshake},
+		{"overall", slowHeader.URL, Timeouts{Overall: 100 * time.Millisecond}, PhaseOverall},
+		{"overall before the phase", slowHeader.URL, Timeouts{ResponseHeader: 5 * time.Second, Overall: 100 * time.Millisecond}, PhaseOverall},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIIntegration{APICode: "TEST", Method: http.MethodGet, Host: tt.host, Timeouts: tt.timeouts}
+			recorder := &MemoryRecorder{}
+			start := time.Now()
+			_, err := a.Do(context.Background(), recorder)
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("Do returned after %s", elapsed)
+			}
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want an *APIError", err)
+			}
+			if apiErr.Kind != KindTimeout || apiErr.Phase != tt.phase {
+				t.Errorf("error = %v, want a %s timeout", err, tt.phase)
+			}
+
+			activities := recorder.Activities()
+			if len(activities) != 1 {
+				t.Fatalf("recorded %d activities, want 1", len(activities))
+			}
+			if activities[0].ResponseError == "" {
+				t.Errorf("activity records no error: %+v", activities[0])
+			}
+			if tt.phase != PhaseOverall && !strings.Contains(activities[0].ResponseError, tt.phase.String()+" timeout") {
+				t.Errorf("response error = %q, want the %s timeout", activities[0].ResponseError, tt.phase)
+			}
+		})
+	}
+}
+
+func TestTimeoutsOverrideTransportLimits(t *testing.T) {
+	// the pool sets no limit of its own that would cut a longer phase short
+	client := NewClient(ClientOptions{})
+	if client.transport.TLSHandshakeTimeout != 0 || client.dialer.Timeout != 0 {
+		t.Errorf("transport limits: tls handshake %s, dial %s", client.transport.TLSHandshakeTimeout, client.dialer.Timeout)
+	}
+
+	got := Timeouts{TLSHandshake: 20 * time.Second}.withDefaults()
+	if got.Dial != defaultDialTimeout || got.TLSHandshake != 20*time.Second {
+		t.Errorf("timeouts = %+v", got)
+	}
+}
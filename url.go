@@ -0,0 +1,66 @@
+package apiintegration
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// generateURL fills the path params of Host and adds QueryParams to it. With
+// QueryParams the whole query is re-encoded, sorted by key.
+func (a *APIIntegration) generateURL() (string, error) {
+	host, err := expandPathParams(a.Host, a.PathParams)
+	if err != nil {
+		return "", err
+	}
+	if a.QueryParams == nil {
+		return host, nil
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	params, err := encodeValues(a.QueryParams, "query")
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// expandPathParams replaces every {name} of template with the path escaped
+// value of name in params.
+func expandPathParams(template string, params map[string]string) (string, error) {
+	if !strings.Contains(template, "{") {
+		return template, nil
+	}
+
+	var b strings.Builder
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed path param in %q", template)
+		}
+		name := rest[start+1 : start+end]
+		value, ok := params[name]
+		if !ok {
+			return "", fmt.Errorf("missing path param %q", name)
+		}
+		b.WriteString(rest[:start])
+		b.WriteString(url.PathEscape(value))
+		rest = rest[start+end+1:]
+	}
+}
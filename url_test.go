@@ -0,0 +1,81 @@
+package apiintegration
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+type testOrderQuery struct {
+	Status   string    `query:"status"`
+	Page     int       `json:"page"`
+	PageSize int       `query:"page_size,omitempty"`
+	Tags     []string  `query:"tag"`
+	Since    time.Time `query:"since"`
+	Cursor   *string   `query:"cursor"`
+	Secret   string    `query:"-"`
+	Sort     string
+	internal string
+}
+
+func TestGenerateURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        string
+		pathParams  map[string]string
+		queryParams interface{}
+		want        string
+	}{
+		{"plain", "https://api.example.com/orders?b=2&a=1", nil, nil, "https://api.example.com/orders?b=2&a=1"},
+		{"path params", "https://api.example.com/customers/{customer}/orders/{id}",
+			map[string]string{"customer": "c 1/2", "id": "ä?#"}, nil,
+			"https://api.example.com/customers/c%201%2F2/orders/%C3%A4%3F%23"},
+		{"url values", "https://api.example.com/orders", nil, url.Values{"status": {"paid", "new"}}, "https://api.example.com/orders?status=paid&status=new"},
+		{"string map", "https://api.example.com/orders", nil, map[string]string{"q": "a&b c", "page": "2"}, "https://api.example.com/orders?page=2&q=a%26b+c"},
+		{"string slice map", "https://api.example.com/orders", nil, map[string][]string{"id": {"1", "2"}}, "https://api.example.com/orders?id=1&id=2"},
+		{"interface map", "https://api.example.com/orders", nil, map[string]interface{}{"page": 2, "paid": true, "id": []int{1, 2}, "none": nil},
+			"https://api.example.com/orders?id=1&id=2&page=2&paid=true"},
+		{"struct", "https://api.example.com/orders", nil,
+			testOrderQuery{Status: "paid", Page: 2, Tags: []string{"a", "b"}, Since: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Secret: "x", Sort: "id"},
+			"https://api.example.com/orders?Sort=id&page=2&since=2024-01-02T03%3A04%3A05Z&status=paid&tag=a&tag=b"},
+		{"struct pointer", "https://api.example.com/orders", nil, &testOrderQuery{Status: "new", Cursor: new(string)},
+			"https://api.example.com/orders?Sort=&cursor=&page=0&since=0001-01-01T00%3A00%3A00Z&status=new"},
+		{"merged with host query", "https://api.example.com/orders/{id}?z=1&status=all", map[string]string{"id": "7"}, map[string]string{"status": "paid", "a": "2"},
+			"https://api.example.com/orders/7?a=2&status=all&status=paid&z=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIIntegration{Host: tt.host, PathParams: tt.pathParams, QueryParams: tt.queryParams}
+			got, err := a.generateURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("url = %s\nwant  %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateURLErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        string
+		pathParams  map[string]string
+		queryParams interface{}
+	}{
+		{"missing path param", "https://api.example.com/orders/{id}", map[string]string{"order": "7"}, nil},
+		{"unclosed path param", "https://api.example.com/orders/{id", map[string]string{"id": "7"}, nil},
+		{"unsupported query params", "https://api.example.com/orders", nil, []string{"a"}},
+		{"unsupported query value", "https://api.example.com/orders", nil, map[string]interface{}{"f": func() {}}},
+		{"bad host", "http://[::1/{id}", map[string]string{"id": "7"}, map[string]string{"a": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &APIIntegration{Host: tt.host, PathParams: tt.pathParams, QueryParams: tt.queryParams}
+			if got, err := a.generateURL(); err == nil {
+				t.Errorf("url = %s, want an error", got)
+			}
+		})
+	}
+}